@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"expvar"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,50 +12,212 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
+	"github.com/signaling-server/internal/auth"
 	"github.com/signaling-server/internal/config"
 	"github.com/signaling-server/internal/handler"
+	"github.com/signaling-server/internal/metrics"
 	"github.com/signaling-server/internal/middleware"
+	"github.com/signaling-server/internal/model"
+	"github.com/signaling-server/internal/ratelimit"
 	"github.com/signaling-server/internal/repository"
 	"github.com/signaling-server/internal/service"
+	"github.com/signaling-server/internal/tlsconfig"
 	"github.com/signaling-server/pkg/logger"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
-	// Initialize logger
-	log := logger.New()
-	log.Info("Starting signaling server...")
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML config file; environment variables override its settings")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flag.Parse()
 
 	// Load configuration
-	cfg := config.Load()
-	log.Infof("Server configuration loaded: %s:%s", cfg.Server.Host, cfg.Server.Port)
+	cfg, err := config.Load(*configFile)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		if err := cfg.Redacted().WriteYAML(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to print configuration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Initialize Redis client
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     fmt.Sprintf("%s:%s", cfg.Redis.Host, cfg.Redis.Port),
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
+	// Initialize logger
+	log, err := logger.New(logger.Config{
+		Level:  cfg.Log.Level,
+		Format: cfg.Log.Format,
+		Redact: cfg.Log.Redact,
 	})
+	if err != nil {
+		logger.Default().Errorf("Invalid logging configuration: %v", err)
+		os.Exit(1)
+	}
+	log = log.With(logger.FieldPod, cfg.Server.NodeID)
+	log.Infof("Starting signaling server %s...", version)
+	log.Infof("Server configuration loaded: %s:%s", cfg.Server.Host, cfg.Server.Port)
 
-	// Test Redis connection
+	// Initialize repositories
 	ctx := context.Background()
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Errorf("Failed to connect to Redis: %v", err)
+	var (
+		userRepo   repository.User
+		roomRepo   repository.Room
+		pubsub     repository.PubSub
+		presence   repository.Presence
+		rateLimits repository.RateLimit
+		store      repository.Pinger
+		closeStore func() error
+	)
+
+	switch cfg.Store.Backend {
+	case "memory":
+		memoryRepo := repository.NewMemoryRepository()
+		userRepo, roomRepo, pubsub, presence, rateLimits, store = memoryRepo, memoryRepo, memoryRepo, memoryRepo, memoryRepo, memoryRepo
+		closeStore = memoryRepo.Close
+		log.Info("Using in-memory store (single node only)")
+	case "redis":
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%s", cfg.Redis.Host, cfg.Redis.Port),
+			Password: cfg.Redis.Password,
+			DB:       cfg.Redis.DB,
+		})
+		redisClient.AddHook(metrics.RedisHook{})
+
+		// Test Redis connection
+		if err := redisClient.Ping(ctx).Err(); err != nil {
+			log.Errorf("Failed to connect to Redis: %v", err)
+			os.Exit(1)
+		}
+		log.Info("Connected to Redis successfully")
+
+		redisRepo := repository.NewRedisRepository(redisClient)
+		userRepo, roomRepo, pubsub, presence, rateLimits, store = redisRepo, redisRepo, redisRepo, redisRepo, redisRepo, redisRepo
+		closeStore = redisClient.Close
+	default:
+		log.Errorf("Unknown store backend: %q (expected \"redis\" or \"memory\")", cfg.Store.Backend)
 		os.Exit(1)
 	}
-	log.Info("Connected to Redis successfully")
 
-	// Initialize repositories
-	redisRepo := repository.NewRedisRepository(redisClient)
+	// Initialize authentication
+	var verifier auth.Verifier
+	switch cfg.Auth.Mode {
+	case "none":
+		log.Warn("Authentication disabled; any client can open /ws")
+	case "jwt":
+		jwtVerifier, err := auth.NewJWTVerifier(auth.JWTConfig{
+			HMACSecret:    cfg.Auth.JWTHMACSecret,
+			PublicKeyFile: cfg.Auth.JWTPublicKeyFile,
+			JWKSFile:      cfg.Auth.JWTJWKSFile,
+			Issuer:        cfg.Auth.JWTIssuer,
+			Audience:      cfg.Auth.JWTAudience,
+			Leeway:        30 * time.Second,
+		})
+		if err != nil {
+			log.Errorf("Failed to initialize JWT authentication: %v", err)
+			os.Exit(1)
+		}
+		verifier = jwtVerifier
+	default:
+		log.Errorf("Unknown auth mode: %q (expected \"none\" or \"jwt\")", cfg.Auth.Mode)
+		os.Exit(1)
+	}
+
+	// Browser origins and the session cookie
+	origins, err := middleware.NewOriginPolicy(cfg.CORS.AllowedOrigins)
+	if err != nil {
+		log.Errorf("Invalid ALLOWED_ORIGINS: %v", err)
+		os.Exit(1)
+	}
+	sameSite, err := middleware.ParseSameSite(cfg.Session.CookieSameSite)
+	if err != nil {
+		log.Errorf("Invalid SESSION_COOKIE_SAMESITE: %v", err)
+		os.Exit(1)
+	}
+	cookieConfig := middleware.CookieConfig{Secure: cfg.Session.CookieSecure, SameSite: sameSite}
+
+	// TLS, when the server terminates it instead of the ingress
+	var (
+		tlsConfig    *tls.Config
+		certReloader *tlsconfig.CertReloader
+	)
+	if cfg.Server.TLSCertFile != "" {
+		tlsConfig, certReloader, err = tlsconfig.New(tlsconfig.Config{
+			CertFile:     cfg.Server.TLSCertFile,
+			KeyFile:      cfg.Server.TLSKeyFile,
+			ClientCAFile: cfg.Server.TLSClientCAFile,
+		})
+		if err != nil {
+			log.Errorf("Failed to initialize TLS: %v", err)
+			os.Exit(1)
+		}
+	}
 
 	// Initialize services
-	userService := service.NewUserService(redisRepo)
-	roomService := service.NewRoomService(redisRepo, redisRepo)
-	signalingService := service.NewSignalingService(userService, roomService, redisRepo, log)
+	userService := service.NewUserService(userRepo)
+	roomService := service.NewRoomService(roomRepo, userRepo, service.RoomConfig{
+		Defaults: model.RoomSettings{
+			MaxParticipants:  cfg.Room.MaxParticipants,
+			MaxPublishers:    cfg.Room.MaxPublishers,
+			AllowScreenShare: cfg.Room.AllowScreenShare,
+			E2EERequired:     cfg.Room.E2EERequired,
+		},
+		LobbyEnabled:         cfg.Room.LobbyEnabled,
+		MaxParticipantsLimit: max(cfg.Room.MaxParticipantsLimit, cfg.Room.MaxParticipants),
+		ChatHistorySize:      cfg.Room.ChatHistorySize,
+	})
+	iceService := service.NewICEService(service.ICEConfig{
+		STUNURLs:      cfg.STUN.URLs,
+		TURNURLs:      cfg.STUN.TURNURLs,
+		TURNSURLs:     cfg.STUN.TURNSURLs,
+		TURNSecret:    cfg.STUN.TURNSecret,
+		CredentialTTL: time.Duration(cfg.STUN.CredentialTTL) * time.Second,
+	})
+	if cfg.STUN.TURNSecret == "" {
+		log.Warn("TURN_SECRET is not set, clients will only be offered STUN servers")
+	}
+
+	rateLimitService := service.NewRateLimitService(rateLimits, rateLimitConfig(cfg))
+
+	signalingService := service.NewSignalingService(
+		userService,
+		roomService,
+		iceService,
+		rateLimitService,
+		pubsub,
+		presence,
+		service.SignalingConfig{
+			NodeID:         cfg.Server.NodeID,
+			PresenceTTL:    time.Duration(cfg.Server.PresenceTTL) * time.Second,
+			ReconnectGrace: time.Duration(cfg.Session.ReconnectGrace) * time.Second,
+		},
+		log,
+	)
+
+	// Start cross-node message routing
+	routingCtx, stopRouting := context.WithCancel(ctx)
+	defer stopRouting()
+	if err := signalingService.Start(routingCtx); err != nil {
+		log.Errorf("Failed to start signaling service: %v", err)
+		os.Exit(1)
+	}
+
+	expvar.Publish("send_queue", expvar.Func(func() interface{} {
+		return signalingService.SendQueueStats()
+	}))
+	metrics.RegisterRooms(signalingService.RoomSizes)
 
 	// Initialize handlers
-	healthHandler := handler.NewHealthHandler()
-	wsHandler := handler.NewWebSocketHandler(signalingService, userService, cfg, log)
+	healthHandler := handler.NewHealthHandler(store, signalingService, version)
+	wsHandler := handler.NewWebSocketHandler(signalingService, userService, rateLimitService, origins, cfg, log)
 
 	// Setup HTTP server with middleware
 	mux := http.NewServeMux()
@@ -61,9 +226,42 @@ func main() {
 	mux.HandleFunc("/health", healthHandler.Health)
 	mux.HandleFunc("/ready", healthHandler.Ready)
 
+	// With HEALTH_PORT set, probes and scrapers get a plain HTTP listener of
+	// their own and metrics are no longer served on the main port
+	opsMux := mux
+	if cfg.Server.HealthPort != "" {
+		opsMux = http.NewServeMux()
+		opsMux.HandleFunc("/health", healthHandler.Health)
+		opsMux.HandleFunc("/ready", healthHandler.Ready)
+	}
+
+	// Runtime stats, including per-connection send queue depth
+	opsMux.Handle("/debug/vars", expvar.Handler())
+
+	// Prometheus metrics
+	opsMux.Handle("/metrics", promhttp.Handler())
+
+	// Operator API, only when an admin token or client CA is configured;
+	// with both, requests need a client certificate and the token
+	if cfg.Admin.Token != "" || cfg.Server.TLSClientCAFile != "" {
+		var adminHandler http.Handler = handler.NewAdminHandler(signalingService, log)
+		if cfg.Admin.Token != "" {
+			adminHandler = middleware.AdminAuthMiddleware(cfg.Admin.Token)(adminHandler)
+		}
+		if cfg.Server.TLSClientCAFile != "" {
+			adminHandler = middleware.ClientCertMiddleware()(adminHandler)
+		}
+		mux.Handle(handler.AdminPrefix+"/", adminHandler)
+	} else {
+		log.Info("ADMIN_TOKEN and TLS_CLIENT_CA_FILE are not set, admin API disabled")
+	}
+
 	// WebSocket endpoint with middleware
-	wsEndpoint := middleware.SessionMiddleware(http.HandlerFunc(wsHandler.HandleWebSocket))
-	mux.Handle("/ws", middleware.CORSMiddleware(wsEndpoint))
+	wsEndpoint := middleware.SessionMiddleware(cookieConfig)(http.HandlerFunc(wsHandler.HandleWebSocket))
+	if verifier != nil {
+		wsEndpoint = middleware.AuthMiddleware(verifier)(wsEndpoint)
+	}
+	mux.Handle("/ws", middleware.CORSMiddleware(origins)(wsEndpoint))
 
 	// Static file serving for development/testing
 	mux.Handle("/", http.FileServer(http.Dir("./web/static/")))
@@ -72,20 +270,83 @@ func main() {
 	server := &http.Server{
 		Addr:         fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
 		Handler:      mux,
+		TLSConfig:    tlsConfig,
 		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout) * time.Second,
 		WriteTimeout: time.Duration(cfg.Server.WriteTimeout) * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
 
-	// Start server in a goroutine
+	// Start server in a goroutine. With TLS, HTTP/2 is negotiated through
+	// ALPN; WebSocket clients still upgrade over HTTP/1.1.
 	go func() {
-		log.Infof("Server starting on %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			log.Infof("Server starting on %s with TLS", server.Addr)
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			log.Infof("Server starting on %s", server.Addr)
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Errorf("Server failed to start: %v", err)
 			os.Exit(1)
 		}
 	}()
 
+	// Pick up renewed certificates without a restart
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	if certReloader != nil && cfg.Server.TLSReloadInterval > 0 {
+		go certReloader.Watch(watchCtx, time.Duration(cfg.Server.TLSReloadInterval)*time.Second, log)
+	}
+
+	var healthServer *http.Server
+	if cfg.Server.HealthPort != "" {
+		healthServer = &http.Server{
+			Addr:         fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.HealthPort),
+			Handler:      opsMux,
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		go func() {
+			log.Infof("Health and metrics server starting on %s", healthServer.Addr)
+			if err := healthServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("Health server failed to start: %v", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
+	// Reload the log level, rate limits, ICE servers and allowed origins on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			next, err := config.Load(*configFile)
+			if err == nil {
+				err = next.Validate()
+			}
+			if err == nil {
+				err = origins.Update(next.CORS.AllowedOrigins)
+			}
+			if err != nil {
+				log.Errorf("Configuration not reloaded: %v", err)
+				continue
+			}
+
+			if err := log.SetLevel(next.Log.Level); err != nil {
+				log.Errorf("Failed to set log level: %v", err)
+			}
+			rateLimitService.SetConfig(rateLimitConfig(next))
+			iceService.SetURLs(next.STUN.URLs, next.STUN.TURNURLs, next.STUN.TURNSURLs)
+			signalingService.RefreshConnections()
+			log.Infof("Configuration reloaded, log level %s", next.Log.Level)
+			if cfg.NeedsRestart(next) {
+				log.Warn("Configuration has changes that only take effect after a restart")
+			}
+		}
+	}()
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -93,18 +354,53 @@ func main() {
 	log.Info("Shutting down server...")
 
 	// Create a deadline for shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	drainPeriod := time.Duration(cfg.Server.DrainPeriod) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), drainPeriod+15*time.Second)
 	defer cancel()
 
+	// Fail readiness, move clients to other pods and clean up their rooms.
+	// Routing and the store stay up until this is done.
+	signalingService.Drain(ctx, drainPeriod)
+
 	// Shutdown server
 	if err := server.Shutdown(ctx); err != nil {
 		log.Errorf("Server forced to shutdown: %v", err)
 	}
+	stopWatching()
+
+	// Probes keep seeing the pod as not ready until the main server is gone
+	if healthServer != nil {
+		if err := healthServer.Shutdown(ctx); err != nil {
+			log.Errorf("Health server forced to shutdown: %v", err)
+		}
+	}
 
-	// Close Redis connection
-	if err := redisClient.Close(); err != nil {
-		log.Errorf("Failed to close Redis connection: %v", err)
+	// Stop cross-node routing before closing the store
+	stopRouting()
+
+	// Close store connection
+	if err := closeStore(); err != nil {
+		log.Errorf("Failed to close store: %v", err)
 	}
 
 	log.Info("Server exited")
 }
+
+// rateLimitConfig converts the rate limit settings for the rate limit service
+func rateLimitConfig(cfg *config.Config) service.RateLimitConfig {
+	return service.RateLimitConfig{
+		Messages: ratelimit.Limit{
+			Rate:  float64(cfg.RateLimit.MessageRate),
+			Burst: cfg.RateLimit.MessageBurst,
+		},
+		ICECandidates: ratelimit.Limit{
+			Rate:  float64(cfg.RateLimit.ICECandidateRate),
+			Burst: cfg.RateLimit.ICECandidateBurst,
+		},
+		MaxViolations:            cfg.RateLimit.MaxViolations,
+		MaxConnectionsPerIP:      cfg.RateLimit.MaxConnectionsPerIP,
+		MaxConnectionsPerSession: cfg.RateLimit.MaxConnectionsPerSession,
+		JoinsPerMinute:           cfg.RateLimit.JoinsPerMinute,
+		SlotTTL:                  time.Duration(cfg.Server.PresenceTTL) * time.Second,
+	}
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/signaling-server/internal/model"
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature does not verify
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned when a token is outside its validity window
+	ErrExpiredToken = errors.New("token expired or not yet valid")
+)
+
+// Verifier validates a bearer token and returns the identity it asserts
+type Verifier interface {
+	Verify(token string) (*model.Identity, error)
+}
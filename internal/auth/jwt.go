@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256" // register SHA-256 for crypto.Hash
+	_ "crypto/sha512" // register SHA-384/SHA-512 for crypto.Hash
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/signaling-server/internal/model"
+)
+
+// JWTConfig configures which keys and claims a JWTVerifier accepts
+type JWTConfig struct {
+	// HMACSecret enables HS256/HS384/HS512 tokens
+	HMACSecret string
+	// PublicKeyFile is a PEM encoded RSA public key or certificate for RS256/RS384/RS512
+	PublicKeyFile string
+	// JWKSFile is a JSON Web Key Set with RSA keys, selected by the token's kid
+	JWKSFile string
+	// Issuer and Audience, when set, must match the iss and aud claims
+	Issuer   string
+	Audience string
+	// Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+// JWTVerifier verifies HMAC and RSA signed JSON Web Tokens
+type JWTVerifier struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+	issuer     string
+	audience   string
+	leeway     time.Duration
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// NewJWTVerifier loads the configured keys. At least one of HMACSecret,
+// PublicKeyFile or JWKSFile must be set.
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{
+		rsaKeys:  make(map[string]*rsa.PublicKey),
+		issuer:   config.Issuer,
+		audience: config.Audience,
+		leeway:   config.Leeway,
+	}
+
+	if config.HMACSecret != "" {
+		v.hmacSecret = []byte(config.HMACSecret)
+	}
+
+	if config.PublicKeyFile != "" {
+		key, err := loadRSAPublicKey(config.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKeys[""] = key
+	}
+
+	if config.JWKSFile != "" {
+		if err := v.loadJWKS(config.JWKSFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if v.hmacSecret == nil && len(v.rsaKeys) == 0 {
+		return nil, fmt.Errorf("no JWT verification keys configured")
+	}
+
+	return v, nil
+}
+
+// Verify checks the token signature and validity window and maps its claims
+// to an identity. The sub claim is required.
+func (v *JWTVerifier) Verify(token string) (*model.Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad header: %v", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad signature encoding", ErrInvalidToken)
+	}
+
+	if err := v.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad claims: %v", ErrInvalidToken, err)
+	}
+
+	if err := v.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return identityFromClaims(claims)
+}
+
+func (v *JWTVerifier) verifySignature(header jwtHeader, signingInput string, signature []byte) error {
+	switch header.Alg {
+	case "HS256", "HS384", "HS512":
+		if v.hmacSecret == nil {
+			return fmt.Errorf("%w: HMAC tokens are not accepted", ErrInvalidToken)
+		}
+		mac := hmac.New(hashFor(header.Alg).New, v.hmacSecret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+		return nil
+	case "RS256", "RS384", "RS512":
+		key, ok := v.rsaKeys[header.Kid]
+		if !ok && header.Kid != "" {
+			// Fall back to a key loaded from PEM, which has no kid
+			key, ok = v.rsaKeys[""]
+		}
+		if !ok {
+			return fmt.Errorf("%w: unknown key id %q", ErrInvalidToken, header.Kid)
+		}
+		hash := hashFor(header.Alg)
+		hasher := hash.New()
+		hasher.Write([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(key, hash, hasher.Sum(nil), signature); err != nil {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+}
+
+func (v *JWTVerifier) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+
+	// A token without exp would stay valid forever if leaked
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("%w: missing exp claim", ErrInvalidToken)
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(v.leeway)) {
+		return ErrExpiredToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(v.leeway).Before(time.Unix(int64(nbf), 0)) {
+			return ErrExpiredToken
+		}
+	}
+
+	if v.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.issuer {
+			return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+		}
+	}
+
+	if v.audience != "" && !containsString(stringList(claims["aud"]), v.audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// identityFromClaims maps sub, name (or preferred_username), rooms and role
+// to an identity and keeps every other string claim for room policies
+func identityFromClaims(claims map[string]interface{}) (*model.Identity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing sub claim", ErrInvalidToken)
+	}
+
+	identity := &model.Identity{
+		Subject: subject,
+		Rooms:   stringList(claims["rooms"]),
+		Claims:  make(map[string]string),
+	}
+	identity.DisplayName, _ = claims["name"].(string)
+	if identity.DisplayName == "" {
+		identity.DisplayName, _ = claims["preferred_username"].(string)
+	}
+	identity.Role, _ = claims["role"].(string)
+
+	for name, value := range claims {
+		if str, ok := value.(string); ok {
+			identity.Claims[name] = str
+		}
+	}
+
+	return identity, nil
+}
+
+func (v *JWTVerifier) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse JWKS file: %w", err)
+	}
+
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return fmt.Errorf("invalid modulus for key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return fmt.Errorf("invalid exponent for key %q: %w", key.Kid, err)
+		}
+
+		v.rsaKeys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(v.rsaKeys) == 0 {
+		return fmt.Errorf("no RSA signing keys found in %s", path)
+	}
+	return nil
+}
+
+func loadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key file: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		if key, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			return key, nil
+		}
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		if rsaKey, ok := key.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s does not contain an RSA public key", path)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func hashFor(alg string) crypto.Hash {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384
+	case "512":
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
+
+// stringList accepts a claim that is either a JSON array of strings or a
+// single space-separated string
+func stringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
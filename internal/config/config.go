@@ -1,69 +1,309 @@
 package config
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Config is the server configuration. Load builds it from the defaults, an
+// optional YAML file and environment variables, in increasing precedence.
 type Config struct {
-	Server ServerConfig
-	Redis  RedisConfig
-	STUN   STUNConfig
+	Server    ServerConfig    `yaml:"server"`
+	Log       LogConfig       `yaml:"log"`
+	Session   SessionConfig   `yaml:"session"`
+	CORS      CORSConfig      `yaml:"cors"`
+	Room      RoomConfig      `yaml:"room"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Store     StoreConfig     `yaml:"store"`
+	Redis     RedisConfig     `yaml:"redis"`
+	STUN      STUNConfig      `yaml:"stun"`
 }
 
 type ServerConfig struct {
-	Port         string
-	Host         string
-	ReadTimeout  int
-	WriteTimeout int
+	Port         string `yaml:"port"`
+	Host         string `yaml:"host"`
+	ReadTimeout  int    `yaml:"read_timeout"`
+	WriteTimeout int    `yaml:"write_timeout"`
+	// NodeID identifies this signaling instance for cross-pod message routing
+	NodeID string `yaml:"node_id"`
+	// PresenceTTL is how long (in seconds) a user's node registration lives without refresh
+	PresenceTTL int `yaml:"presence_ttl"`
+	// SendQueueSize bounds each connection's outbound message queue and
+	// SendQueueOverflow picks what happens when it fills: "drop_ice" or "disconnect"
+	SendQueueSize     int    `yaml:"send_queue_size"`
+	SendQueueOverflow string `yaml:"send_queue_overflow"`
+	// DrainPeriod is how long (in seconds) clients get to reconnect elsewhere
+	// on shutdown before their connections are closed
+	DrainPeriod int `yaml:"drain_period"`
+	// MaxMessageSize is the largest WebSocket frame (in bytes) accepted from clients
+	MaxMessageSize int `yaml:"max_message_size"`
+
+	// TLSCertFile and TLSKeyFile enable HTTPS (and HTTP/2) on Port. The files
+	// are checked for changes every TLSReloadInterval seconds (0 never).
+	TLSCertFile       string `yaml:"tls_cert_file"`
+	TLSKeyFile        string `yaml:"tls_key_file"`
+	TLSReloadInterval int    `yaml:"tls_reload_interval"`
+	// TLSClientCAFile enables client certificates; the admin API then
+	// requires one signed by these CAs
+	TLSClientCAFile string `yaml:"tls_client_ca_file"`
+	// HealthPort, when set, serves health checks, metrics and debug vars over
+	// plain HTTP on a separate port; metrics and debug vars leave Port
+	HealthPort string `yaml:"health_port"`
+}
+
+// LogConfig controls the server log. Level is "debug", "info", "warn" or
+// "error" and Format is "text" or "json". Redact hides SDP, ICE candidates
+// and other message payloads in log fields.
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+	Redact bool   `yaml:"redact"`
+}
+
+type SessionConfig struct {
+	// ReconnectGrace is how long (in seconds) a disconnected user keeps their
+	// room membership so a reconnect with the same session cookie can resume
+	ReconnectGrace int `yaml:"reconnect_grace"`
+	// CookieSecure sets the session cookie's Secure flag and CookieSameSite
+	// its SameSite attribute: "lax", "strict" or "none" (which needs Secure)
+	CookieSecure   bool   `yaml:"cookie_secure"`
+	CookieSameSite string `yaml:"cookie_samesite"`
+}
+
+// CORSConfig lists the browser origins, besides the server's own, that may
+// make cross-origin requests and open WebSockets. Entries are exact origins
+// ("https://meet.example.com"), wildcard subdomains ("https://*.example.com"),
+// regular expressions between slashes or "*" for any origin.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// RoomConfig holds the settings of rooms that don't override them in
+// create_room or the admin API. MaxParticipantsLimit caps the
+// max_participants a room may ask for; MaxPublishers 0 lets every member publish.
+// ChatHistorySize is how many recent chat messages a room keeps for late
+// joiners (0 none).
+type RoomConfig struct {
+	MaxParticipants      int  `yaml:"max_participants"`
+	MaxParticipantsLimit int  `yaml:"max_participants_limit"`
+	MaxPublishers        int  `yaml:"max_publishers"`
+	AllowScreenShare     bool `yaml:"allow_screen_share"`
+	E2EERequired         bool `yaml:"e2ee_required"`
+	LobbyEnabled         bool `yaml:"lobby_enabled"`
+	ChatHistorySize      int  `yaml:"chat_history_size"`
+}
+
+// RateLimitConfig bounds what a single client may do; zero disables a limit.
+// Message rates are per connection and message type, in messages per second
+// with bursts of up to the burst size. MaxViolations refused messages within
+// a minute close the connection. The connection and join limits are keyed by
+// client IP (and session) and shared across nodes; TrustProxy takes the
+// client IP from X-Forwarded-For.
+type RateLimitConfig struct {
+	MessageRate              int  `yaml:"message_rate"`
+	MessageBurst             int  `yaml:"message_burst"`
+	ICECandidateRate         int  `yaml:"ice_candidate_rate"`
+	ICECandidateBurst        int  `yaml:"ice_candidate_burst"`
+	MaxViolations            int  `yaml:"max_violations"`
+	MaxConnectionsPerIP      int  `yaml:"max_connections_per_ip"`
+	MaxConnectionsPerSession int  `yaml:"max_connections_per_session"`
+	JoinsPerMinute           int  `yaml:"joins_per_minute"`
+	TrustProxy               bool `yaml:"trust_proxy"`
+}
+
+// AuthConfig controls authentication of /ws connections.
+// Mode is "none" (anonymous cookie sessions) or "jwt" (a valid token is required).
+type AuthConfig struct {
+	Mode             string `yaml:"mode"`
+	JWTHMACSecret    string `yaml:"jwt_hmac_secret"`
+	JWTPublicKeyFile string `yaml:"jwt_public_key_file"`
+	JWTJWKSFile      string `yaml:"jwt_jwks_file"`
+	JWTIssuer        string `yaml:"jwt_issuer"`
+	JWTAudience      string `yaml:"jwt_audience"`
+}
+
+// AdminConfig protects the /admin/api operator API. The API is disabled
+// unless Token or a TLS client CA is set; requests must send Token as a
+// bearer token.
+type AdminConfig struct {
+	Token string `yaml:"token"`
+}
+
+// StoreConfig selects the repository backend: "redis" (default) or "memory".
+// The memory backend keeps all state in-process and only supports a single node.
+type StoreConfig struct {
+	Backend string `yaml:"backend"`
 }
 
 type RedisConfig struct {
-	Host     string
-	Port     string
-	Password string
-	DB       int
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
 }
 
+// STUNConfig lists the ICE servers handed to clients. TURN and TURNS URLs
+// are only advertised when TURNSecret is set, since every TURN server gets
+// per-connection credentials derived from the coturn static-auth-secret.
 type STUNConfig struct {
-	URLs []string
+	URLs      []string `yaml:"urls"`
+	TURNURLs  []string `yaml:"turn_urls"`
+	TURNSURLs []string `yaml:"turns_urls"`
+	// TURNSecret is the coturn static-auth-secret used to sign credentials
+	TURNSecret string `yaml:"turn_secret"`
+	// CredentialTTL is how long (in seconds) issued TURN credentials stay valid
+	CredentialTTL int `yaml:"credential_ttl"`
+}
+
+// Load builds the configuration from the defaults, the YAML file at path
+// (if not empty) and the environment. Unknown keys in the file and values
+// that don't parse are errors; call Validate to check the result.
+func Load(path string) (*Config, error) {
+	config := Default()
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.loadEnv(); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
-func Load() *Config {
+// Default returns the built-in configuration
+func Default() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port:         getEnv("SERVER_PORT", "8080"),
-			Host:         getEnv("SERVER_HOST", "0.0.0.0"),
-			ReadTimeout:  getEnvAsInt("READ_TIMEOUT", 60),
-			WriteTimeout: getEnvAsInt("WRITE_TIMEOUT", 60),
+			Port:         "8080",
+			Host:         "0.0.0.0",
+			ReadTimeout:  60,
+			WriteTimeout: 60,
+			NodeID:       getHostname(),
+			PresenceTTL:  90,
+
+			SendQueueSize:     256,
+			SendQueueOverflow: "drop_ice",
+			DrainPeriod:       15,
+			MaxMessageSize:    65536,
+
+			TLSReloadInterval: 30,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+			Redact: true,
+		},
+		Session: SessionConfig{
+			ReconnectGrace: 30,
+			CookieSameSite: "lax",
+		},
+		Room: RoomConfig{
+			MaxParticipants:      10,
+			MaxParticipantsLimit: 50,
+			AllowScreenShare:     true,
+			ChatHistorySize:      50,
+		},
+		RateLimit: RateLimitConfig{
+			MessageRate:              20,
+			MessageBurst:             40,
+			ICECandidateRate:         100,
+			ICECandidateBurst:        500,
+			MaxViolations:            50,
+			MaxConnectionsPerIP:      50,
+			MaxConnectionsPerSession: 10,
+			JoinsPerMinute:           30,
+		},
+		Auth: AuthConfig{
+			Mode: "none",
+		},
+		Store: StoreConfig{
+			Backend: "redis",
 		},
 		Redis: RedisConfig{
-			Host:     getEnv("REDIS_HOST", "localhost"),
-			Port:     getEnv("REDIS_PORT", "6379"),
-			Password: getEnv("REDIS_PASSWORD", ""),
-			DB:       getEnvAsInt("REDIS_DB", 0),
+			Host: "localhost",
+			Port: "6379",
 		},
 		STUN: STUNConfig{
-			URLs: []string{
-				getEnv("STUN_URL", "stun:localhost:3478"),
-				getEnv("TURN_URL", "turn:localhost:3478"),
-			},
+			URLs:          []string{"stun:localhost:3478"},
+			TURNURLs:      []string{"turn:localhost:3478"},
+			CredentialTTL: 3600,
 		},
 	}
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// loadFile overlays the settings present in a YAML file
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
 	}
-	return defaultValue
+	return nil
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// redacted replaces secrets in Redacted
+const redacted = "[REDACTED]"
+
+// Redacted returns a copy of c with passwords, tokens and secrets replaced,
+// for printing
+func (c *Config) Redacted() *Config {
+	redact := func(secret *string) {
+		if *secret != "" {
+			*secret = redacted
 		}
 	}
-	return defaultValue
+	out := *c
+	redact(&out.Auth.JWTHMACSecret)
+	redact(&out.Admin.Token)
+	redact(&out.Redis.Password)
+	redact(&out.STUN.TURNSecret)
+	return &out
+}
+
+// WriteYAML writes c as a YAML config file
+func (c *Config) WriteYAML(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+func getHostname() string {
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return "signaling"
+}
+
+// NeedsRestart reports whether next changes settings other than those a
+// running server reloads: the log level, rate limits (except TrustProxy),
+// STUN/TURN URLs and allowed origins
+func (c *Config) NeedsRestart(next *Config) bool {
+	return !reflect.DeepEqual(c.restartOnly(), next.restartOnly())
+}
+
+// restartOnly returns c without its reloadable settings
+func (c *Config) restartOnly() Config {
+	out := *c
+	out.Log.Level = ""
+	out.RateLimit = RateLimitConfig{TrustProxy: c.RateLimit.TrustProxy}
+	out.STUN.URLs, out.STUN.TURNURLs, out.STUN.TURNSURLs = nil, nil, nil
+	out.CORS = CORSConfig{}
+	return out
 }
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadEnv overlays the settings given as environment variables
+func (c *Config) loadEnv() error {
+	var env envReader
+
+	env.string("SERVER_PORT", &c.Server.Port)
+	env.string("SERVER_HOST", &c.Server.Host)
+	env.int("READ_TIMEOUT", &c.Server.ReadTimeout)
+	env.int("WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	env.string("POD_NAME", &c.Server.NodeID)
+	env.int("PRESENCE_TTL", &c.Server.PresenceTTL)
+	env.int("SEND_QUEUE_SIZE", &c.Server.SendQueueSize)
+	env.string("SEND_QUEUE_OVERFLOW", &c.Server.SendQueueOverflow)
+	env.int("DRAIN_PERIOD", &c.Server.DrainPeriod)
+	env.int("MAX_MESSAGE_SIZE", &c.Server.MaxMessageSize)
+	env.string("TLS_CERT_FILE", &c.Server.TLSCertFile)
+	env.string("TLS_KEY_FILE", &c.Server.TLSKeyFile)
+	env.int("TLS_RELOAD_INTERVAL", &c.Server.TLSReloadInterval)
+	env.string("TLS_CLIENT_CA_FILE", &c.Server.TLSClientCAFile)
+	env.string("HEALTH_PORT", &c.Server.HealthPort)
+
+	env.string("LOG_LEVEL", &c.Log.Level)
+	env.string("LOG_FORMAT", &c.Log.Format)
+	env.bool("LOG_REDACT", &c.Log.Redact)
+
+	env.int("RECONNECT_GRACE_PERIOD", &c.Session.ReconnectGrace)
+	env.bool("SESSION_COOKIE_SECURE", &c.Session.CookieSecure)
+	env.string("SESSION_COOKIE_SAMESITE", &c.Session.CookieSameSite)
+
+	env.list("ALLOWED_ORIGINS", &c.CORS.AllowedOrigins)
+
+	env.int("ROOM_MAX_PARTICIPANTS", &c.Room.MaxParticipants)
+	env.int("ROOM_MAX_PARTICIPANTS_LIMIT", &c.Room.MaxParticipantsLimit)
+	env.int("ROOM_MAX_PUBLISHERS", &c.Room.MaxPublishers)
+	env.bool("ROOM_ALLOW_SCREEN_SHARE", &c.Room.AllowScreenShare)
+	env.bool("ROOM_E2EE_REQUIRED", &c.Room.E2EERequired)
+	env.bool("ROOM_LOBBY_ENABLED", &c.Room.LobbyEnabled)
+	env.int("ROOM_CHAT_HISTORY_SIZE", &c.Room.ChatHistorySize)
+
+	env.int("RATE_LIMIT_MESSAGE_RATE", &c.RateLimit.MessageRate)
+	env.int("RATE_LIMIT_MESSAGE_BURST", &c.RateLimit.MessageBurst)
+	env.int("RATE_LIMIT_ICE_RATE", &c.RateLimit.ICECandidateRate)
+	env.int("RATE_LIMIT_ICE_BURST", &c.RateLimit.ICECandidateBurst)
+	env.int("RATE_LIMIT_MAX_VIOLATIONS", &c.RateLimit.MaxViolations)
+	env.int("MAX_CONNECTIONS_PER_IP", &c.RateLimit.MaxConnectionsPerIP)
+	env.int("MAX_CONNECTIONS_PER_SESSION", &c.RateLimit.MaxConnectionsPerSession)
+	env.int("ROOM_JOINS_PER_MINUTE", &c.RateLimit.JoinsPerMinute)
+	env.bool("TRUST_PROXY", &c.RateLimit.TrustProxy)
+
+	env.string("AUTH_MODE", &c.Auth.Mode)
+	env.string("JWT_HMAC_SECRET", &c.Auth.JWTHMACSecret)
+	env.string("JWT_PUBLIC_KEY_FILE", &c.Auth.JWTPublicKeyFile)
+	env.string("JWT_JWKS_FILE", &c.Auth.JWTJWKSFile)
+	env.string("JWT_ISSUER", &c.Auth.JWTIssuer)
+	env.string("JWT_AUDIENCE", &c.Auth.JWTAudience)
+
+	env.string("ADMIN_TOKEN", &c.Admin.Token)
+
+	env.string("STORE_BACKEND", &c.Store.Backend)
+
+	env.string("REDIS_HOST", &c.Redis.Host)
+	env.string("REDIS_PORT", &c.Redis.Port)
+	env.string("REDIS_PASSWORD", &c.Redis.Password)
+	env.int("REDIS_DB", &c.Redis.DB)
+
+	// STUN_URL and TURN_URL predate the lists and are overridden by them
+	env.list("STUN_URL", &c.STUN.URLs)
+	env.list("STUN_URLS", &c.STUN.URLs)
+	env.list("TURN_URL", &c.STUN.TURNURLs)
+	env.list("TURN_URLS", &c.STUN.TURNURLs)
+	env.list("TURNS_URLS", &c.STUN.TURNSURLs)
+	env.string("TURN_SECRET", &c.STUN.TURNSecret)
+	env.int("TURN_CREDENTIAL_TTL", &c.STUN.CredentialTTL)
+
+	return errors.Join(env.errs...)
+}
+
+// envReader sets config fields from the environment variables that are set
+// and not empty, collecting the values that fail to parse
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) string(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
+	}
+}
+
+func (e *envReader) int(key string, dst *int) {
+	if value := os.Getenv(key); value != "" {
+		intValue, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+			return
+		}
+		*dst = intValue
+	}
+}
+
+func (e *envReader) bool(key string, dst *bool) {
+	if value := os.Getenv(key); value != "" {
+		boolValue, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+			return
+		}
+		*dst = boolValue
+	}
+}
+
+// list splits a comma-separated variable, dropping empty entries
+func (e *envReader) list(key string, dst *[]string) {
+	value := os.Getenv(key)
+	if value == "" {
+		return
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	*dst = list
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Validate checks every setting and returns all problems found, each naming
+// the setting by its config file key and environment variable
+func (c *Config) Validate() error {
+	var v validator
+
+	v.port(c.Server.Port, false, "server.port", "SERVER_PORT")
+	v.positive(c.Server.ReadTimeout, "server.read_timeout", "READ_TIMEOUT")
+	v.positive(c.Server.WriteTimeout, "server.write_timeout", "WRITE_TIMEOUT")
+	v.check(c.Server.NodeID != "", "server.node_id", "POD_NAME", "must not be empty")
+	v.positive(c.Server.PresenceTTL, "server.presence_ttl", "PRESENCE_TTL")
+	v.positive(c.Server.SendQueueSize, "server.send_queue_size", "SEND_QUEUE_SIZE")
+	v.oneOf(c.Server.SendQueueOverflow, "server.send_queue_overflow", "SEND_QUEUE_OVERFLOW", "drop_ice", "disconnect")
+	v.nonNegative(c.Server.DrainPeriod, "server.drain_period", "DRAIN_PERIOD")
+	v.positive(c.Server.MaxMessageSize, "server.max_message_size", "MAX_MESSAGE_SIZE")
+	v.check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tls_cert_file", "TLS_CERT_FILE",
+		"must be set together with server.tls_key_file (TLS_KEY_FILE)")
+	v.nonNegative(c.Server.TLSReloadInterval, "server.tls_reload_interval", "TLS_RELOAD_INTERVAL")
+	v.check(c.Server.TLSClientCAFile == "" || c.Server.TLSCertFile != "", "server.tls_client_ca_file", "TLS_CLIENT_CA_FILE",
+		"requires server.tls_cert_file (TLS_CERT_FILE)")
+	v.port(c.Server.HealthPort, true, "server.health_port", "HEALTH_PORT")
+	v.check(c.Server.HealthPort == "" || c.Server.HealthPort != c.Server.Port, "server.health_port", "HEALTH_PORT",
+		"must differ from server.port")
+
+	v.oneOf(strings.ToLower(c.Log.Level), "log.level", "LOG_LEVEL", "debug", "info", "warn", "error")
+	v.oneOf(strings.ToLower(c.Log.Format), "log.format", "LOG_FORMAT", "text", "json")
+
+	v.nonNegative(c.Session.ReconnectGrace, "session.reconnect_grace", "RECONNECT_GRACE_PERIOD")
+	v.oneOf(strings.ToLower(c.Session.CookieSameSite), "session.cookie_samesite", "SESSION_COOKIE_SAMESITE", "lax", "strict", "none")
+	v.check(!strings.EqualFold(c.Session.CookieSameSite, "none") || c.Session.CookieSecure, "session.cookie_samesite", "SESSION_COOKIE_SAMESITE",
+		"none requires session.cookie_secure (SESSION_COOKIE_SECURE)")
+
+	v.positive(c.Room.MaxParticipants, "room.max_participants", "ROOM_MAX_PARTICIPANTS")
+	v.nonNegative(c.Room.MaxParticipantsLimit, "room.max_participants_limit", "ROOM_MAX_PARTICIPANTS_LIMIT")
+	v.nonNegative(c.Room.MaxPublishers, "room.max_publishers", "ROOM_MAX_PUBLISHERS")
+	v.nonNegative(c.Room.ChatHistorySize, "room.chat_history_size", "ROOM_CHAT_HISTORY_SIZE")
+
+	v.nonNegative(c.RateLimit.MessageRate, "rate_limit.message_rate", "RATE_LIMIT_MESSAGE_RATE")
+	v.nonNegative(c.RateLimit.MessageBurst, "rate_limit.message_burst", "RATE_LIMIT_MESSAGE_BURST")
+	v.nonNegative(c.RateLimit.ICECandidateRate, "rate_limit.ice_candidate_rate", "RATE_LIMIT_ICE_RATE")
+	v.nonNegative(c.RateLimit.ICECandidateBurst, "rate_limit.ice_candidate_burst", "RATE_LIMIT_ICE_BURST")
+	v.nonNegative(c.RateLimit.MaxViolations, "rate_limit.max_violations", "RATE_LIMIT_MAX_VIOLATIONS")
+	v.nonNegative(c.RateLimit.MaxConnectionsPerIP, "rate_limit.max_connections_per_ip", "MAX_CONNECTIONS_PER_IP")
+	v.nonNegative(c.RateLimit.MaxConnectionsPerSession, "rate_limit.max_connections_per_session", "MAX_CONNECTIONS_PER_SESSION")
+	v.nonNegative(c.RateLimit.JoinsPerMinute, "rate_limit.joins_per_minute", "ROOM_JOINS_PER_MINUTE")
+
+	v.oneOf(c.Auth.Mode, "auth.mode", "AUTH_MODE", "none", "jwt")
+	v.check(c.Auth.Mode != "jwt" || c.Auth.JWTHMACSecret != "" || c.Auth.JWTPublicKeyFile != "" || c.Auth.JWTJWKSFile != "",
+		"auth.mode", "AUTH_MODE", "jwt requires a JWT HMAC secret, public key file or JWKS file")
+
+	v.oneOf(c.Store.Backend, "store.backend", "STORE_BACKEND", "redis", "memory")
+	if c.Store.Backend == "redis" {
+		v.check(c.Redis.Host != "", "redis.host", "REDIS_HOST", "must not be empty")
+		v.port(c.Redis.Port, false, "redis.port", "REDIS_PORT")
+		v.nonNegative(c.Redis.DB, "redis.db", "REDIS_DB")
+	}
+
+	v.positive(c.STUN.CredentialTTL, "stun.credential_ttl", "TURN_CREDENTIAL_TTL")
+
+	return errors.Join(v.errs...)
+}
+
+// validator collects the problems found by Validate
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(ok bool, key, env, format string, args ...any) {
+	if !ok {
+		v.errs = append(v.errs, fmt.Errorf("%s (%s): %s", key, env, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) positive(value int, key, env string) {
+	v.check(value > 0, key, env, "must be positive, got %d", value)
+}
+
+func (v *validator) nonNegative(value int, key, env string) {
+	v.check(value >= 0, key, env, "must not be negative, got %d", value)
+}
+
+func (v *validator) oneOf(value, key, env string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.check(false, key, env, "must be one of %q, got %q", allowed, value)
+}
+
+// port checks a TCP port number; optional ports may be empty
+func (v *validator) port(value string, optional bool, key, env string) {
+	if optional && value == "" {
+		return
+	}
+	port, err := strconv.Atoi(value)
+	v.check(err == nil && port > 0 && port <= 65535, key, env, "must be a port number, got %q", value)
+}
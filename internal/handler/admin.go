@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/signaling-server/internal/model"
+	"github.com/signaling-server/internal/repository"
+	"github.com/signaling-server/internal/service"
+	"github.com/signaling-server/pkg/logger"
+)
+
+// AdminPrefix is where the admin API is mounted
+const AdminPrefix = "/admin/api"
+
+// AdminHandler serves the operator REST API:
+//
+//	GET    /admin/api/rooms?cursor=&limit=       page through rooms with members and their nodes
+//	POST   /admin/api/rooms                      create a room with its settings
+//	GET    /admin/api/rooms/{room}               room details, including the lobby
+//	DELETE /admin/api/rooms/{room}               close the room for everyone
+//	DELETE /admin/api/rooms/{room}/users/{user}  kick a member
+//	POST   /admin/api/rooms/{room}/notice        send {"message": "..."} to the room
+//	GET    /admin/api/connections                connections held by this pod
+type AdminHandler struct {
+	signalingService *service.SignalingService
+	logger           *logger.Logger
+}
+
+func NewAdminHandler(signalingService *service.SignalingService, logger *logger.Logger) *AdminHandler {
+	return &AdminHandler{
+		signalingService: signalingService,
+		logger:           logger,
+	}
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// roomPage is a page of rooms, most recently active first. NextCursor is
+// passed back as ?cursor= for the next page and is empty on the last one.
+type roomPage struct {
+	Rooms      []service.RoomDetails `json:"rooms"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+	Total      int                   `json:"total"`
+}
+
+const (
+	defaultRoomPageSize = 50
+	maxRoomPageSize     = 500
+)
+
+// createRoomRequest creates a room. Omitted settings take the server defaults.
+type createRoomRequest struct {
+	RoomID   string                 `json:"room_id"`
+	Password string                 `json:"password,omitempty"`
+	Settings model.RoomSettingsData `json:"settings"`
+}
+
+type noticeRequest struct {
+	Message string `json:"message"`
+}
+
+type noticeResponse struct {
+	Delivered int `json:"delivered"`
+}
+
+// ServeHTTP routes requests below AdminPrefix
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, AdminPrefix), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "rooms":
+		switch r.Method {
+		case http.MethodGet:
+			h.listRooms(w, r)
+		case http.MethodPost:
+			h.createRoom(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+	case path == "connections":
+		h.allow(w, r, http.MethodGet, h.listConnections)
+	case len(parts) == 2 && parts[0] == "rooms":
+		switch r.Method {
+		case http.MethodGet:
+			h.getRoom(w, r, parts[1])
+		case http.MethodDelete:
+			h.closeRoom(w, r, parts[1])
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+	case len(parts) == 3 && parts[0] == "rooms" && parts[2] == "notice":
+		h.allow(w, r, http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			h.sendNotice(w, r, parts[1])
+		})
+	case len(parts) == 4 && parts[0] == "rooms" && parts[2] == "users":
+		h.allow(w, r, http.MethodDelete, func(w http.ResponseWriter, r *http.Request) {
+			h.kickUser(w, r, parts[1], parts[3])
+		})
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+func (h *AdminHandler) allow(w http.ResponseWriter, r *http.Request, method string, handle http.HandlerFunc) {
+	if r.Method != method {
+		methodNotAllowed(w, method)
+		return
+	}
+	handle(w, r)
+}
+
+func (h *AdminHandler) listRooms(w http.ResponseWriter, r *http.Request) {
+	limit := defaultRoomPageSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be a positive integer"})
+			return
+		}
+		limit = min(parsed, maxRoomPageSize)
+	}
+
+	rooms, next, err := h.signalingService.ListRoomDetails(r.Context(), r.URL.Query().Get("cursor"), limit)
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid cursor"})
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Failed to list rooms", err)
+		return
+	}
+
+	total, err := h.signalingService.CountRooms(r.Context())
+	if err != nil {
+		h.internalError(w, "Failed to count rooms", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, roomPage{Rooms: rooms, NextCursor: next, Total: total})
+}
+
+func (h *AdminHandler) createRoom(w http.ResponseWriter, r *http.Request) {
+	var req createRoomRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "invalid request body"})
+		return
+	}
+	if err := model.ValidateRoomID(req.RoomID); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	if err := model.ValidatePassword(req.Password); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	room, err := h.signalingService.CreateRoom(r.Context(), req.RoomID, req.Password, req.Settings)
+	switch {
+	case errors.Is(err, service.ErrInvalidRoomSettings):
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+	case errors.Is(err, repository.ErrRoomExists):
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "room already exists"})
+	case err != nil:
+		h.internalError(w, "Failed to create room", err)
+	default:
+		writeJSON(w, http.StatusCreated, room)
+	}
+}
+
+func (h *AdminHandler) getRoom(w http.ResponseWriter, r *http.Request, roomID string) {
+	room, err := h.signalingService.GetRoomDetails(r.Context(), roomID)
+	if err != nil {
+		h.internalError(w, "Failed to get room", err)
+		return
+	}
+	if room == nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "room not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, room)
+}
+
+func (h *AdminHandler) closeRoom(w http.ResponseWriter, r *http.Request, roomID string) {
+	err := h.signalingService.CloseRoom(r.Context(), roomID)
+	if errors.Is(err, repository.ErrRoomNotFound) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "room not found"})
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Failed to close room", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) kickUser(w http.ResponseWriter, r *http.Request, roomID, userID string) {
+	err := h.signalingService.KickUser(r.Context(), roomID, userID)
+	if errors.Is(err, service.ErrUserNotInRoom) {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "user is not in the room"})
+		return
+	}
+	if err != nil {
+		h.internalError(w, "Failed to kick user", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) sendNotice(w http.ResponseWriter, r *http.Request, roomID string) {
+	var req noticeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil || strings.TrimSpace(req.Message) == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: "message is required"})
+		return
+	}
+
+	delivered, err := h.signalingService.SendNotice(r.Context(), roomID, req.Message)
+	if err != nil {
+		h.internalError(w, "Failed to send notice", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, noticeResponse{Delivered: delivered})
+}
+
+func (h *AdminHandler) listConnections(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.signalingService.Connections())
+}
+
+func (h *AdminHandler) internalError(w http.ResponseWriter, message string, err error) {
+	h.logger.Errorf("Admin API: %s: %v", message, err)
+	writeJSON(w, http.StatusInternalServerError, errorResponse{Error: message})
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+}
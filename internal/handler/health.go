@@ -1,42 +1,134 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"time"
+
+	"github.com/signaling-server/internal/repository"
+	"github.com/signaling-server/internal/service"
 )
 
-type HealthHandler struct{}
+const (
+	// pingTimeout bounds how long a readiness check waits on the store
+	pingTimeout = 2 * time.Second
+	// maxPingLatency is the slowest store ping that still counts as ready
+	maxPingLatency = 500 * time.Millisecond
+)
 
-func NewHealthHandler() *HealthHandler {
-	return &HealthHandler{}
+type HealthHandler struct {
+	store            repository.Pinger
+	signalingService *service.SignalingService
+	version          string
+	startedAt        time.Time
+}
+
+// NewHealthHandler creates a handler that checks store with its ping and
+// reports the state of signalingService. version is the build version.
+func NewHealthHandler(store repository.Pinger, signalingService *service.SignalingService, version string) *HealthHandler {
+	return &HealthHandler{
+		store:            store,
+		signalingService: signalingService,
+		version:          version,
+		startedAt:        time.Now(),
+	}
 }
 
 type HealthResponse struct {
-	Status  string `json:"status"`
-	Message string `json:"message"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Version     string `json:"version"`
+	Node        string `json:"node"`
+	Uptime      string `json:"uptime"`
+	Connections int    `json:"connections"`
+}
+
+// ReadyResponse reports every readiness check; Status is "ready" only if all passed
+type ReadyResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
 }
 
-// Health returns the health status of the service
+// ComponentStatus is the result of one readiness check
+type ComponentStatus struct {
+	Status    string  `json:"status"`
+	Message   string  `json:"message,omitempty"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+}
+
+// Health is the liveness check. It only reports that the process is serving
+// and never depends on Redis, so an outage does not get pods restarted.
 func (h *HealthHandler) Health(w http.ResponseWriter, r *http.Request) {
 	response := HealthResponse{
-		Status:  "ok",
-		Message: "Signaling server is running",
+		Status:      "ok",
+		Message:     "Signaling server is running",
+		Version:     h.version,
+		Node:        h.signalingService.NodeID(),
+		Uptime:      time.Since(h.startedAt).Round(time.Second).String(),
+		Connections: h.signalingService.ConnectionCount(),
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	writeJSON(w, http.StatusOK, response)
 }
 
-// Ready returns the readiness status of the service
+// Ready returns 200 only if the store answers promptly, the routing
+// subscription is up and the server is not draining; otherwise 503
 func (h *HealthHandler) Ready(w http.ResponseWriter, r *http.Request) {
-	// In a real implementation, you might check database connectivity, etc.
-	response := HealthResponse{
-		Status:  "ready",
-		Message: "Signaling server is ready to accept connections",
+	response := ReadyResponse{
+		Status: "ready",
+		Components: map[string]ComponentStatus{
+			"store":  h.checkStore(r.Context()),
+			"pubsub": h.checkPubSub(),
+			"drain":  h.checkDrain(),
+		},
+	}
+
+	status := http.StatusOK
+	for _, component := range response.Components {
+		if component.Status != "ok" {
+			response.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
 	}
 
+	writeJSON(w, status, response)
+}
+
+func (h *HealthHandler) checkStore(ctx context.Context) ComponentStatus {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.store.Ping(ctx)
+	latency := time.Since(start)
+
+	result := ComponentStatus{Status: "ok", LatencyMS: float64(latency.Microseconds()) / 1000}
+	switch {
+	case err != nil:
+		result.Status, result.Message = "error", err.Error()
+	case latency > maxPingLatency:
+		result.Status, result.Message = "degraded", "ping slower than "+maxPingLatency.String()
+	}
+	return result
+}
+
+func (h *HealthHandler) checkPubSub() ComponentStatus {
+	if !h.signalingService.RoutingSubscribed() {
+		return ComponentStatus{Status: "error", Message: "not subscribed to the node routing channel"}
+	}
+	return ComponentStatus{Status: "ok"}
+}
+
+func (h *HealthHandler) checkDrain() ComponentStatus {
+	if h.signalingService.Draining() {
+		return ComponentStatus{Status: "draining", Message: "server is shutting down"}
+	}
+	return ComponentStatus{Status: "ok"}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
@@ -2,28 +2,34 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"regexp"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"github.com/signaling-server/internal/config"
+	"github.com/signaling-server/internal/metrics"
 	"github.com/signaling-server/internal/middleware"
+	"github.com/signaling-server/internal/model"
 	"github.com/signaling-server/internal/service"
+	"github.com/signaling-server/internal/wsconn"
 	"github.com/signaling-server/pkg/logger"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// In production, implement proper origin checking
-		return true
-	},
-}
+// pingInterval is how often idle connections are pinged to keep them alive
+const pingInterval = 30 * time.Second
+
+// tabIDPattern matches the tab query parameter
+var tabIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
 
 type WebSocketHandler struct {
 	signalingService *service.SignalingService
 	userService      *service.UserService
+	rateLimits       *service.RateLimitService
+	origins          *middleware.OriginPolicy
+	upgrader         websocket.Upgrader
 	config           *config.Config
 	logger           *logger.Logger
 }
@@ -31,57 +37,143 @@ type WebSocketHandler struct {
 func NewWebSocketHandler(
 	signalingService *service.SignalingService,
 	userService *service.UserService,
+	rateLimits *service.RateLimitService,
+	origins *middleware.OriginPolicy,
 	config *config.Config,
 	logger *logger.Logger,
 ) *WebSocketHandler {
 	return &WebSocketHandler{
 		signalingService: signalingService,
 		userService:      userService,
-		config:           config,
-		logger:           logger,
+		rateLimits:       rateLimits,
+		origins:          origins,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// Echo the token marker back so browsers passing a token as a subprotocol accept the upgrade
+			Subprotocols: []string{middleware.TokenSubprotocol},
+			// Pages on other origins would otherwise ride on the session cookie
+			CheckOrigin: origins.CheckOrigin,
+		},
+		config: config,
+		logger: logger,
 	}
 }
 
 // HandleWebSocket upgrades HTTP connection to WebSocket and handles signaling
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// A draining node sends new clients to the other pods
+	if h.signalingService.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Every line logged for this connection carries its conn_id and session_id
+	connID := uuid.New().String()
+	log := h.logger.With(logger.FieldConnID, connID)
+
+	if !h.origins.CheckOrigin(r) {
+		log.Warnf("Rejecting WebSocket upgrade from origin %q", r.Header.Get("Origin"))
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return
+	}
+
 	// Get session ID from middleware
 	sessionID := middleware.GetSessionID(r)
 	if sessionID == "" {
-		h.logger.Error("No session ID found")
+		log.Error("No session ID found")
 		http.Error(w, "No session found", http.StatusBadRequest)
 		return
 	}
+	log = log.With(logger.FieldSessionID, sessionID)
 
-	// Upgrade connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		h.logger.Errorf("Failed to upgrade connection: %v", err)
+	// Tabs sharing the session cookie tell themselves apart with a tab ID, so
+	// each gets its own user and a reconnecting tab resumes its own
+	sessionKey := sessionID
+	if tab := r.URL.Query().Get("tab"); tab != "" {
+		if !tabIDPattern.MatchString(tab) {
+			http.Error(w, "Invalid tab ID", http.StatusBadRequest)
+			return
+		}
+		sessionKey = sessionID + "/" + tab
+	}
+
+	// Authenticated connections use the token subject as their user ID
+	identity := middleware.GetIdentity(r)
+	if identity == nil && h.config.Auth.Mode != "none" {
+		log.Warn("Rejecting unauthenticated WebSocket upgrade")
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
 		return
 	}
-	defer conn.Close()
+
+	// Count the connection against its IP and session before doing any work
+	// for it. The limits fail open if the store is unreachable.
+	ctx := logger.NewContext(context.Background(), log)
+	remoteIP := middleware.ClientIP(r, h.config.RateLimit.TrustProxy)
+	err := h.rateLimits.AcquireConnection(ctx, remoteIP, sessionID, connID)
+	if errors.Is(err, service.ErrTooManyConnections) {
+		log.Warnf("Rejecting connection: %v", err)
+		metrics.RateLimited.WithLabelValues("connection").Inc()
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		log.Errorf("Failed to check connection limits: %v", err)
+	}
+	defer func() {
+		if err := h.rateLimits.ReleaseConnection(context.Background(), remoteIP, sessionID, connID); err != nil {
+			log.Errorf("Failed to release connection limits: %v", err)
+		}
+	}()
 
 	// Create or get user
-	ctx := context.Background()
-	user, err := h.userService.GetOrCreateUser(ctx, sessionID)
+	session, resumed, err := h.resolveUser(ctx, sessionKey, identity)
 	if err != nil {
-		h.logger.Errorf("Failed to create user: %v", err)
+		log.Errorf("Failed to create user: %v", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
+	// The upgrade response is written on the hijacked connection and ignores
+	// w.Header(), so forward the session cookie set by SessionMiddleware
+	responseHeader := http.Header{}
+	if cookies := w.Header().Values("Set-Cookie"); len(cookies) > 0 {
+		responseHeader["Set-Cookie"] = cookies
+	}
+
+	// Upgrade connection to WebSocket
+	conn, err := h.upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		log.Errorf("Failed to upgrade connection: %v", err)
+		return
+	}
+
+	// All writes go through the connection's send queue and writer goroutine
+	out := wsconn.New(conn, wsconn.Config{
+		QueueSize:    h.config.Server.SendQueueSize,
+		Overflow:     wsconn.OverflowPolicy(h.config.Server.SendQueueOverflow),
+		WriteTimeout: time.Duration(h.config.Server.WriteTimeout) * time.Second,
+		PingInterval: pingInterval,
+	})
+	defer out.Close(websocket.CloseNormalClosure, "")
+
 	// Use the user ID from the created/retrieved user
-	userID := user.ID
+	userID := session.ID
+	log = log.With(logger.FieldUserID, userID)
+	ctx = logger.NewContext(ctx, log)
 
 	// Add connection to signaling service
-	_, err = h.signalingService.AddConnection(userID, conn, sessionID)
+	user, err := h.signalingService.AddConnection(userID, out, sessionID, remoteIP, identity)
 	if err != nil {
-		h.logger.Errorf("Failed to add connection: %v", err)
+		log.Errorf("Failed to add connection: %v", err)
 		return
 	}
-	defer h.signalingService.RemoveConnection(userID)
+	defer h.signalingService.RemoveConnection(user)
 
-	// Set connection timeouts
+	// Set connection timeouts; oversized frames close the connection with 1009
 	conn.SetReadDeadline(time.Now().Add(time.Duration(h.config.Server.ReadTimeout) * time.Second))
-	conn.SetWriteDeadline(time.Now().Add(time.Duration(h.config.Server.WriteTimeout) * time.Second))
+	conn.SetReadLimit(int64(h.config.Server.MaxMessageSize))
 
 	// Set up ping/pong handlers for connection health
 	conn.SetPongHandler(func(string) error {
@@ -90,47 +182,85 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	})
 
 	// Send STUN/TURN server configuration
-	if err := h.sendSTUNConfig(conn); err != nil {
-		h.logger.Errorf("Failed to send STUN config: %v", err)
+	if err := h.signalingService.SendICEServers(userID); err != nil {
+		log.Errorf("Failed to send STUN config: %v", err)
 	}
 
-	// Handle messages
-	h.handleConnection(ctx, userID, conn)
-}
-
-// handleConnection manages the WebSocket connection lifecycle
-func (h *WebSocketHandler) handleConnection(ctx context.Context, userID string, conn *websocket.Conn) {
-	// Start ping ticker
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	// Channel for handling ping
-	done := make(chan struct{})
+	// Tell a returning client who it was and which room it is still in
+	if resumed {
+		if err := h.signalingService.ResumeSession(ctx, user, session); err != nil {
+			log.Errorf("Failed to resume session: %v", err)
+		}
+	}
 
-	// Goroutine for sending pings
+	// Keep the connection's limit slots from lapsing while it is open
 	go func() {
-		defer close(done)
+		ticker := time.NewTicker(h.rateLimits.RenewInterval())
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-				if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					h.logger.Errorf("Failed to send ping: %v", err)
-					return
+				if err := h.rateLimits.RenewConnection(ctx, remoteIP, sessionID, connID); err != nil {
+					log.Errorf("Failed to renew connection limits: %v", err)
 				}
-			case <-done:
+			case <-out.Done():
 				return
 			}
 		}
 	}()
 
+	// Handle messages
+	h.handleConnection(ctx, userID, conn, out)
+}
+
+// resolveUser finds or creates the user for a new connection. Anonymous
+// connections are keyed by session cookie and tab; authenticated ones by
+// token subject. The user's previous connection is replaced by this one (see
+// AddConnection), so a client reconnecting before its old socket timed out
+// resumes its session.
+func (h *WebSocketHandler) resolveUser(ctx context.Context, sessionKey string, identity *model.Identity) (*model.UserSession, bool, error) {
+	if identity != nil {
+		return h.userService.GetOrCreateUserWithID(ctx, identity.Subject, sessionKey)
+	}
+
+	return h.userService.GetOrCreateUser(ctx, sessionKey)
+}
+
+// handleConnection manages the WebSocket connection lifecycle. It is the
+// connection's only reader; writes and pings are done by out's writer.
+func (h *WebSocketHandler) handleConnection(ctx context.Context, userID string, conn *websocket.Conn, out *wsconn.Conn) {
+	log := logger.FromContext(ctx, h.logger)
+
+	// Reissue TURN credentials before they expire on long calls
+	if interval := h.signalingService.ICERefreshInterval(); interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := h.signalingService.SendICEServers(userID); err != nil {
+						log.Errorf("Failed to refresh STUN config: %v", err)
+					}
+				case <-out.Done():
+					return
+				}
+			}
+		}()
+	}
+
 	// Main message handling loop
 	for {
 		// Read message
 		_, message, err := conn.ReadMessage()
+		if errors.Is(err, websocket.ErrReadLimit) {
+			log.Warnf("Closing connection: message larger than %d bytes", h.config.Server.MaxMessageSize)
+			metrics.MessagesHandled.WithLabelValues("oversized", "invalid").Inc()
+			break
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				h.logger.Errorf("WebSocket error: %v", err)
+				log.Errorf("WebSocket error: %v", err)
 			}
 			break
 		}
@@ -139,47 +269,26 @@ func (h *WebSocketHandler) handleConnection(ctx context.Context, userID string,
 		conn.SetReadDeadline(time.Now().Add(time.Duration(h.config.Server.ReadTimeout) * time.Second))
 
 		// Handle message
-		if err := h.signalingService.HandleMessage(ctx, userID, message); err != nil {
-			h.logger.Errorf("Failed to handle message from user %s: %v", userID, err)
+		err = h.signalingService.HandleMessage(ctx, userID, message)
+		if errors.Is(err, service.ErrRateLimitAbuse) {
+			log.Warn("Closing connection: rate limits repeatedly exceeded")
+			metrics.RateLimitDisconnects.Inc()
+			out.Close(websocket.ClosePolicyViolation, "rate limit exceeded")
+			break
+		}
+		if err != nil {
+			log.Errorf("Failed to handle message: %v", err)
 			// Continue processing other messages instead of breaking
 		}
 
 		// Update user activity
 		if err := h.userService.UpdateUserActivity(ctx, userID); err != nil {
-			h.logger.Errorf("Failed to update user activity: %v", err)
+			log.Errorf("Failed to update user activity: %v", err)
 		}
 	}
 }
 
-// sendSTUNConfig sends STUN/TURN server configuration to the client
-func (h *WebSocketHandler) sendSTUNConfig(conn *websocket.Conn) error {
-	config := map[string]interface{}{
-		"type": "stun_config",
-		"data": map[string]interface{}{
-			"iceServers": []map[string]interface{}{
-				{
-					"urls": []string{"stun:stun.l.google.com:19302"},
-				},
-				{
-					"urls": []string{"stun:stun1.l.google.com:19302"},
-				},
-			},
-		},
-		"timestamp": time.Now().Unix(),
-	}
-
-	if err := conn.WriteJSON(config); err != nil {
-		h.logger.Errorf("Failed to send STUN config: %v", err)
-		return err
-	}
-	
-	h.logger.Info("STUN config sent successfully")
-	return nil
-}
-
-// GetConnectedUsers returns the number of connected users (for monitoring)
+// GetConnectedUsers returns the number of users connected to this node (for monitoring)
 func (h *WebSocketHandler) GetConnectedUsers() int {
-	// This would need to be implemented in the signaling service
-	// For now, return 0 as placeholder
-	return 0
+	return h.signalingService.ConnectionCount()
 }
@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+const namespace = "signaling"
+
+var (
+	// ConnectionsActive is the number of WebSocket connections held by this node
+	ConnectionsActive = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connections_active",
+		Help:      "WebSocket connections currently open on this node.",
+	})
+
+	// MessagesHandled counts client messages by type and outcome: "ok",
+	// "error", "invalid" for messages rejected by validation or
+	// "rate_limited"
+	MessagesHandled = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_handled_total",
+		Help:      "Client messages handled, by message type and result.",
+	}, []string{"type", "result"})
+
+	// ForwardFailures counts messages that could not be forwarded to their
+	// target, by reason ("not_connected" or "error")
+	ForwardFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "forward_failures_total",
+		Help:      "Messages that could not be forwarded to their target user.",
+	}, []string{"reason"})
+
+	// RateLimited counts requests refused by a rate limit, by limit:
+	// "message", "join" or "connection"
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests refused by a rate limit, by limit.",
+	}, []string{"limit"})
+
+	// RateLimitDisconnects counts connections closed for repeatedly exceeding rate limits
+	RateLimitDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_disconnects_total",
+		Help:      "Connections closed for repeatedly exceeding rate limits.",
+	})
+
+	// JoinDuration measures join_room handling by outcome
+	JoinDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "join_duration_seconds",
+		Help:      "Time taken to handle join_room requests, by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// PubSubLag measures how long routed messages took to reach this node
+	PubSubLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pubsub_lag_seconds",
+		Help:      "Delay between publishing a routed message and this node receiving it.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+
+	redisDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_operation_duration_seconds",
+		Help:      "Latency of Redis commands, by command name.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "redis_operation_errors_total",
+		Help:      "Failed Redis commands, by command name.",
+	}, []string{"operation"})
+)
+
+// RoomSizes reports the number of local users in each room this node has members in
+type RoomSizes func() map[string]int
+
+// roomCollector exports rooms and users per room from a snapshot taken at scrape time
+type roomCollector struct {
+	sizes RoomSizes
+	rooms *prometheus.Desc
+	users *prometheus.Desc
+}
+
+// roomUserBuckets covers rooms from one-to-one calls up to the room capacity
+var roomUserBuckets = []float64{1, 2, 3, 4, 5, 6, 8, 10}
+
+// RegisterRooms exports signaling_rooms_active and the
+// signaling_room_users histogram, computed from sizes on every scrape
+func RegisterRooms(sizes RoomSizes) {
+	prometheus.MustRegister(&roomCollector{
+		sizes: sizes,
+		rooms: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "rooms_active"),
+			"Rooms with at least one member connected to this node.",
+			nil, nil,
+		),
+		users: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "room_users"),
+			"Distribution of users per room, counting members connected to this node.",
+			nil, nil,
+		),
+	})
+}
+
+func (c *roomCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.rooms
+	ch <- c.users
+}
+
+func (c *roomCollector) Collect(ch chan<- prometheus.Metric) {
+	sizes := c.sizes()
+
+	buckets := make(map[float64]uint64, len(roomUserBuckets))
+	for _, bound := range roomUserBuckets {
+		buckets[bound] = 0
+	}
+	var sum float64
+	for _, size := range sizes {
+		sum += float64(size)
+		for _, bound := range roomUserBuckets {
+			if float64(size) <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.rooms, prometheus.GaugeValue, float64(len(sizes)))
+	ch <- prometheus.MustNewConstHistogram(c.users, uint64(len(sizes)), sum, buckets)
+}
+
+// RedisHook records the latency and errors of every command sent by a go-redis client
+type RedisHook struct{}
+
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			redisErrors.WithLabelValues("dial").Inc()
+		}
+		return conn, err
+	}
+}
+
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeRedis(cmd.Name(), start, err)
+		return err
+	}
+}
+
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		observeRedis("pipeline", start, err)
+		return err
+	}
+}
+
+// observeRedis records one command. Misses, lost optimistic transactions
+// and the NOSCRIPT reply that makes scripts fall back to EVAL are expected
+// and not counted as errors.
+func observeRedis(operation string, start time.Time, err error) {
+	redisDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	switch {
+	case err == nil, errors.Is(err, redis.Nil), errors.Is(err, redis.TxFailedErr), redis.HasErrorPrefix(err, "NOSCRIPT"):
+		return
+	}
+	redisErrors.WithLabelValues(operation).Inc()
+}
@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/signaling-server/internal/auth"
+	"github.com/signaling-server/internal/model"
+)
+
+const identityKey contextKey = "identity"
+
+// TokenSubprotocol is the WebSocket subprotocol browsers use to pass a token,
+// since they cannot set headers on a WebSocket request:
+//
+//	new WebSocket(url, ["access_token", token])
+const TokenSubprotocol = "access_token"
+
+// AuthMiddleware verifies a bearer token passed as the access_token (or token)
+// query parameter, an Authorization header or the Sec-WebSocket-Protocol
+// header, and attaches the resulting identity to the request context.
+// Requests with an invalid token are rejected; requests without a token pass
+// through unauthenticated and handlers decide whether that is acceptable.
+func AuthMiddleware(verifier auth.Verifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := extractToken(r)
+			if token == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			identity, err := verifier.Verify(token)
+			if err != nil {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			r = r.WithContext(setIdentity(r.Context(), identity))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetIdentity retrieves the authenticated identity from request context
+func GetIdentity(r *http.Request) *model.Identity {
+	if identity, ok := r.Context().Value(identityKey).(*model.Identity); ok {
+		return identity
+	}
+	return nil
+}
+
+// extractToken looks for a token in the query string, the Authorization
+// header and finally the Sec-WebSocket-Protocol header
+func extractToken(r *http.Request) string {
+	query := r.URL.Query()
+	if token := query.Get("access_token"); token != "" {
+		return token
+	}
+	if token := query.Get("token"); token != "" {
+		return token
+	}
+
+	if header := r.Header.Get("Authorization"); header != "" {
+		if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+	}
+
+	// The token follows the access_token marker in the offered protocol list
+	var protocols []string
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocols = append(protocols, strings.TrimSpace(protocol))
+		}
+	}
+	for i := 0; i+1 < len(protocols); i++ {
+		if protocols[i] == TokenSubprotocol {
+			return protocols[i+1]
+		}
+	}
+
+	return ""
+}
+
+// setIdentity adds the identity to context
+func setIdentity(ctx context.Context, identity *model.Identity) context.Context {
+	return context.WithValue(ctx, identityKey, identity)
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OriginPolicy decides which browser origins may call the server
+// cross-origin and open WebSockets. Entries of the allowed list are exact
+// origins ("https://meet.example.com"), wildcard subdomains
+// ("https://*.example.com", which does not match example.com itself and
+// matches any port unless the entry names one), regular expressions that
+// must match the whole origin ("/https://pr-[0-9]+\.example\.com/") or "*"
+// for any origin. Same-origin requests are always allowed.
+type OriginPolicy struct {
+	mu    sync.RWMutex
+	rules *originRules
+}
+
+// originRules is a parsed allowed origins list
+type originRules struct {
+	any       bool
+	exact     map[string]bool
+	wildcards []originWildcard
+	patterns  []*regexp.Regexp
+}
+
+// originWildcard matches origins with scheme whose host name ends in
+// suffix, on port or on any port if port is empty
+type originWildcard struct {
+	scheme string
+	suffix string
+	port   string
+}
+
+// NewOriginPolicy parses the allowed origins list
+func NewOriginPolicy(allowed []string) (*OriginPolicy, error) {
+	rules, err := parseOrigins(allowed)
+	if err != nil {
+		return nil, err
+	}
+	return &OriginPolicy{rules: rules}, nil
+}
+
+// Update replaces the allowed origins list. An invalid list leaves the
+// current one in place.
+func (p *OriginPolicy) Update(allowed []string) error {
+	rules, err := parseOrigins(allowed)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.rules = rules
+	p.mu.Unlock()
+	return nil
+}
+
+func parseOrigins(allowed []string) (*originRules, error) {
+	p := &originRules{exact: make(map[string]bool)}
+	for _, entry := range allowed {
+		switch {
+		case entry == "*":
+			p.any = true
+		case len(entry) > 2 && strings.HasPrefix(entry, "/") && strings.HasSuffix(entry, "/"):
+			// Anchored, so a pattern can't match inside an attacker's origin
+			pattern, err := regexp.Compile("^(?:" + entry[1:len(entry)-1] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid origin pattern %q: %w", entry, err)
+			}
+			p.patterns = append(p.patterns, pattern)
+		default:
+			scheme, host, ok := strings.Cut(strings.ToLower(strings.TrimSuffix(entry, "/")), "://")
+			if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+				return nil, fmt.Errorf("invalid origin %q, expected scheme://host[:port]", entry)
+			}
+			if rest, wildcard := strings.CutPrefix(host, "*."); wildcard {
+				name, port, hasPort := strings.Cut(rest, ":")
+				if name == "" || strings.Contains(name, "*") || hasPort && !validPort(port) {
+					return nil, fmt.Errorf("invalid origin %q, expected scheme://*.domain[:port]", entry)
+				}
+				p.wildcards = append(p.wildcards, originWildcard{scheme: scheme, suffix: "." + name, port: port})
+				continue
+			}
+			if strings.Contains(host, "*") {
+				return nil, fmt.Errorf("invalid origin %q, wildcards must be a leading *.", entry)
+			}
+			p.exact[scheme+"://"+host] = true
+		}
+	}
+	return p, nil
+}
+
+// Allowed reports whether origin is on the allowed list
+func (p *OriginPolicy) Allowed(origin string) bool {
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+	return rules.allowed(origin)
+}
+
+func (p *originRules) allowed(origin string) bool {
+	if p.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.exact[origin] {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && len(p.wildcards) > 0 {
+		name := u.Hostname()
+		for _, w := range p.wildcards {
+			if u.Scheme == w.scheme && len(name) > len(w.suffix) && strings.HasSuffix(name, w.suffix) &&
+				(w.port == "" || u.Port() == w.port) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin reports whether r may proceed: it has no Origin header (not
+// a browser), comes from the server's own origin or from an allowed one. It
+// fits websocket.Upgrader.CheckOrigin.
+func (p *OriginPolicy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || sameOrigin(origin, r) || p.Allowed(origin)
+}
+
+// sameOrigin checks origin against the scheme and host r was sent to
+func sameOrigin(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Scheme, requestScheme(r)) && strings.EqualFold(u.Host, r.Host)
+}
+
+// requestScheme is the scheme the client used: https if the server
+// terminated TLS, else the one reported by the ingress that did
+func requestScheme(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	if proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ","); strings.TrimSpace(proto) != "" {
+		return strings.TrimSpace(proto)
+	}
+	return "http"
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
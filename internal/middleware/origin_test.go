@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicyAllowed(t *testing.T) {
+	policy, err := NewOriginPolicy([]string{
+		"https://meet.example.com",
+		"https://*.example.com",
+		"https://*.staging.example.net:8443",
+		"/https://pr-[0-9]+\\.example\\.org/",
+	})
+	if err != nil {
+		t.Fatalf("NewOriginPolicy: %v", err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://meet.example.com", true},
+		{"HTTPS://Meet.Example.com", true},
+		{"http://meet.example.com", false},
+
+		// Wildcards match subdomains on any port unless the entry names one
+		{"https://app.example.com", true},
+		{"https://app.example.com:8443", true},
+		{"https://a.b.example.com", true},
+		{"https://example.com", false},
+		{"http://app.example.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://evilexample.com", false},
+		{"https://app.staging.example.net:8443", true},
+		{"https://app.staging.example.net", false},
+		{"https://app.staging.example.net:9443", false},
+
+		// Patterns must match the whole origin
+		{"https://pr-42.example.org", true},
+		{"https://pr-42.example.org.evil.com", false},
+		{"https://evil.com/https://pr-42.example.org", false},
+	}
+	for _, tt := range tests {
+		if got := policy.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestOriginPolicyInvalid(t *testing.T) {
+	for _, entry := range []string{
+		"meet.example.com",
+		"https://*",
+		"https://*.example.com:port",
+		"https://app.*.example.com",
+		"/[/",
+	} {
+		if _, err := NewOriginPolicy([]string{entry}); err == nil {
+			t.Errorf("NewOriginPolicy(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestCheckOriginSameOrigin(t *testing.T) {
+	policy, err := NewOriginPolicy(nil)
+	if err != nil {
+		t.Fatalf("NewOriginPolicy: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		origin    string
+		tls       bool
+		forwarded string
+		want      bool
+	}{
+		{name: "no origin", want: true},
+		{name: "plain http", origin: "http://signal.example.com", want: true},
+		{name: "other host", origin: "http://evil.example.com", want: false},
+		{name: "other port", origin: "http://signal.example.com:8080", want: false},
+		{name: "https page, http server", origin: "https://signal.example.com", want: false},
+		{name: "http page, tls server", origin: "http://signal.example.com", tls: true, want: false},
+		{name: "tls server", origin: "https://signal.example.com", tls: true, want: true},
+		{name: "tls ingress", origin: "https://signal.example.com", forwarded: "https", want: true},
+		{name: "tls ingress, http page", origin: "http://signal.example.com", forwarded: "https", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://signal.example.com/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-Proto", tt.forwarded)
+			}
+			if got := policy.CheckOrigin(r); got != tt.want {
+				t.Errorf("CheckOrigin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,7 +2,9 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -13,33 +15,57 @@ const sessionIDKey contextKey = "session_id"
 
 const SessionCookieName = "signaling_session"
 
+// CookieConfig sets the attributes of the session cookie. Secure must be set
+// when SameSite is http.SameSiteNoneMode, which a frontend served from
+// another site needs.
+type CookieConfig struct {
+	Secure   bool
+	SameSite http.SameSite
+}
+
+// ParseSameSite converts "lax", "strict" or "none" to an http.SameSite
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid SameSite %q, expected lax, strict or none", value)
+	}
+}
+
 // SessionMiddleware handles session management via cookies
-func SessionMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check if session cookie exists
-		cookie, err := r.Cookie(SessionCookieName)
-		if err != nil || cookie.Value == "" {
-			// Create new session
-			sessionID := uuid.New().String()
-			http.SetCookie(w, &http.Cookie{
-				Name:     SessionCookieName,
-				Value:    sessionID,
-				Path:     "/",
-				MaxAge:   86400, // 24 hours
-				HttpOnly: true,
-				Secure:   false, // Set to true in production with HTTPS
-				SameSite: http.SameSiteLaxMode,
-			})
-			
-			// Add session ID to request context
-			r = r.WithContext(setSessionID(r.Context(), sessionID))
-		} else {
-			// Use existing session
-			r = r.WithContext(setSessionID(r.Context(), cookie.Value))
-		}
+func SessionMiddleware(cookieConfig CookieConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Check if session cookie exists
+			cookie, err := r.Cookie(SessionCookieName)
+			if err != nil || cookie.Value == "" {
+				// Create new session
+				sessionID := uuid.New().String()
+				http.SetCookie(w, &http.Cookie{
+					Name:     SessionCookieName,
+					Value:    sessionID,
+					Path:     "/",
+					MaxAge:   86400, // 24 hours
+					HttpOnly: true,
+					Secure:   cookieConfig.Secure,
+					SameSite: cookieConfig.SameSite,
+				})
+
+				// Add session ID to request context
+				r = r.WithContext(setSessionID(r.Context(), sessionID))
+			} else {
+				// Use existing session
+				r = r.WithContext(setSessionID(r.Context(), cookie.Value))
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // GetSessionID retrieves session ID from request context
@@ -7,6 +7,7 @@ type MessageType string
 
 const (
 	MessageTypeJoinRoom     MessageType = "join_room"
+	MessageTypeCreateRoom   MessageType = "create_room"
 	MessageTypeLeaveRoom    MessageType = "leave_room"
 	MessageTypeOffer        MessageType = "offer"
 	MessageTypeAnswer       MessageType = "answer"
@@ -15,11 +16,61 @@ const (
 	MessageTypeUserLeft     MessageType = "user_left"
 	MessageTypeRoomFull     MessageType = "room_full"
 	MessageTypeError        MessageType = "error"
+	MessageTypeAck          MessageType = "ack"
+
+	MessageTypeSessionResumed  MessageType = "session_resumed"
+	MessageTypeSessionReplaced MessageType = "session_replaced"
+	MessageTypeSTUNConfig      MessageType = "stun_config"
+	MessageTypeServerDraining  MessageType = "server_draining"
+	MessageTypeSystemNotice    MessageType = "system_notice"
+
+	MessageTypeJoinDenied        MessageType = "join_denied"
+	MessageTypeUpdateRoomPolicy  MessageType = "update_room_policy"
+	MessageTypeRoomPolicyUpdated MessageType = "room_policy_updated"
+
+	// Host/moderator controls
+	MessageTypeKickUser        MessageType = "kick_user"
+	MessageTypeBanUser         MessageType = "ban_user"
+	MessageTypeEndRoom         MessageType = "end_room"
+	MessageTypeTransferHost    MessageType = "transfer_host"
+	MessageTypeRequestMute     MessageType = "request_mute"
+	MessageTypeRemovedFromRoom MessageType = "removed_from_room"
+	MessageTypeRoomEnded       MessageType = "room_ended"
+	MessageTypeHostChanged     MessageType = "host_changed"
+	MessageTypeMuteRequested   MessageType = "mute_requested"
+
+	// Lobby
+	MessageTypeLobbyWaiting  MessageType = "lobby_waiting"
+	MessageTypeLobbyRequest  MessageType = "lobby_request"
+	MessageTypeAdmit         MessageType = "admit"
+	MessageTypeDeny          MessageType = "deny"
+	MessageTypeLobbyAdmitted MessageType = "lobby_admitted"
+	MessageTypeLobbyDenied   MessageType = "lobby_denied"
+	MessageTypeLobbyResolved MessageType = "lobby_resolved"
+
+	// Chat
+	MessageTypeChatMessage MessageType = "chat_message"
+)
+
+// JoinDeniedReason explains why a room policy refused a join
+type JoinDeniedReason string
+
+const (
+	JoinDeniedLocked           JoinDeniedReason = "locked"
+	JoinDeniedNotAllowed       JoinDeniedReason = "not_allowed"
+	JoinDeniedPasswordRequired JoinDeniedReason = "password_required"
+	JoinDeniedInvalidPassword  JoinDeniedReason = "invalid_password"
+	JoinDeniedBanned           JoinDeniedReason = "banned"
+	JoinDeniedE2EERequired     JoinDeniedReason = "e2ee_required"
+	JoinDeniedPublishersFull   JoinDeniedReason = "publishers_full"
 )
 
-// Message represents a WebRTC signaling message
+// Message represents a WebRTC signaling message. A client may set RequestID
+// on any message; the server echoes it on every direct reply so the client
+// can match replies to requests.
 type Message struct {
 	Type      MessageType     `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
 	RoomID    string          `json:"room_id,omitempty"`
 	UserID    string          `json:"user_id,omitempty"`
 	TargetID  string          `json:"target_id,omitempty"`
@@ -46,21 +97,151 @@ type IceCandidateData struct {
 	SDPMLineIndex int    `json:"sdpMLineIndex"`
 }
 
-// JoinRoomData represents join room request data
+// JoinRoomData represents join room request data. E2EE declares that the
+// client supports end-to-end encryption and ReceiveOnly that it will not
+// send media, so it takes no publisher slot.
 type JoinRoomData struct {
+	RoomID      string `json:"room_id"`
+	Password    string `json:"password,omitempty"`
+	E2EE        bool   `json:"e2ee,omitempty"`
+	ReceiveOnly bool   `json:"receive_only,omitempty"`
+}
+
+// CreateRoomData represents a create_room request. The room is created with
+// Settings and Password, and the creator joins it as owner and host.
+type CreateRoomData struct {
+	JoinRoomData
+	Settings RoomSettingsData `json:"settings"`
+}
+
+// RoomSettingsData holds a new room's overrides of the server defaults.
+// Omitted fields take the default.
+type RoomSettingsData struct {
+	MaxParticipants  *int  `json:"max_participants,omitempty"`
+	MaxPublishers    *int  `json:"max_publishers,omitempty"`
+	AllowScreenShare *bool `json:"allow_screen_share,omitempty"`
+	E2EERequired     *bool `json:"e2ee_required,omitempty"`
+	LobbyEnabled     *bool `json:"lobby_enabled,omitempty"`
+}
+
+// ICEServer is an RTCIceServer entry as passed to RTCPeerConnection
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// STUNConfigData represents the ICE servers a client should use. ExpiresAt is
+// the Unix time the TURN credentials stop working; fresh ones are pushed
+// before then.
+type STUNConfigData struct {
+	ICEServers []ICEServer `json:"iceServers"`
+	ExpiresAt  int64       `json:"expires_at,omitempty"`
+}
+
+// JoinDeniedData represents a join refused by the room policy
+type JoinDeniedData struct {
+	RoomID  string           `json:"room_id"`
+	Reason  JoinDeniedReason `json:"reason"`
+	Message string           `json:"message"`
+}
+
+// RoomPolicyUpdateData represents an owner's change to the room policy.
+// Omitted fields are left unchanged; an empty password removes the password.
+type RoomPolicyUpdateData struct {
+	Locked        *bool                `json:"locked,omitempty"`
+	AllowedUsers  *[]string            `json:"allowed_users,omitempty"`
+	AllowedClaims *map[string][]string `json:"allowed_claims,omitempty"`
+	Password      *string              `json:"password,omitempty"`
+	Owner         *string              `json:"owner,omitempty"`
+	LobbyEnabled  *bool                `json:"lobby_enabled,omitempty"`
+}
+
+// ModerationData represents a kick_user, ban_user, transfer_host or
+// request_mute request. Duration is the ban length in seconds (0 bans for the
+// life of the room); Kind is "audio" or "video" for mute requests.
+type ModerationData struct {
+	UserID   string `json:"user_id"`
+	Duration int    `json:"duration,omitempty"`
+	Kind     string `json:"kind,omitempty"`
+}
+
+// RemovedFromRoomData tells a user why they are no longer in the room.
+// Reason is "kicked", "banned" or "room_ended".
+type RemovedFromRoomData struct {
 	RoomID string `json:"room_id"`
+	Reason string `json:"reason"`
+	By     string `json:"by"`
+}
+
+// HostChangedData announces a new room host
+type HostChangedData struct {
+	RoomID       string `json:"room_id"`
+	Host         string `json:"host"`
+	PreviousHost string `json:"previous_host,omitempty"`
+}
+
+// MuteRequestedData asks a user to mute their audio or video
+type MuteRequestedData struct {
+	Kind string `json:"kind"`
+	By   string `json:"by"`
+}
+
+// LobbyData identifies a user waiting in a room's lobby. Outcome is set on
+// lobby_resolved: "admitted", "denied" or "left".
+type LobbyData struct {
+	RoomID  string `json:"room_id"`
+	UserID  string `json:"user_id"`
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// RoomPolicyData represents the room policy as shown to room members
+type RoomPolicyData struct {
+	RoomID            string              `json:"room_id"`
+	Owner             string              `json:"owner"`
+	Locked            bool                `json:"locked"`
+	PasswordProtected bool                `json:"password_protected"`
+	LobbyEnabled      bool                `json:"lobby_enabled"`
+	AllowedUsers      []string            `json:"allowed_users,omitempty"`
+	AllowedClaims     map[string][]string `json:"allowed_claims,omitempty"`
 }
 
-// ErrorData represents error message data
+// ErrorData represents error message data. Reason is set when a message
+// failed validation.
 type ErrorData struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int       `json:"code"`
+	Reason  ErrorCode `json:"reason,omitempty"`
+	Message string    `json:"message"`
+}
+
+// AckData confirms that a request was carried out: a join or leave
+// completed, or an offer, answer or candidate was handed to its target
+type AckData struct {
+	For MessageType `json:"for"`
 }
 
-// UserJoinedData represents user joined notification data
+// UserJoinedData represents user joined notification data. ChatHistory is
+// only set for the joining user, with the room's recent chat oldest first.
 type UserJoinedData struct {
-	UserID string   `json:"user_id"`
-	Users  []string `json:"users"`
+	UserID      string              `json:"user_id"`
+	Users       []string            `json:"users"`
+	Roles       map[string]RoomRole `json:"roles,omitempty"`
+	Settings    *RoomSettings       `json:"settings,omitempty"`
+	Publishers  []string            `json:"publishers,omitempty"`
+	ChatHistory []ChatMessageData   `json:"chat_history,omitempty"`
+}
+
+// ChatMessageData is a chat message. Clients send only Text, and the
+// envelope's target_id for a direct message; the server assigns the ID,
+// fills in the room, sender and recipient and stamps SentAt in Unix
+// milliseconds.
+type ChatMessageData struct {
+	ID     string `json:"id,omitempty"`
+	RoomID string `json:"room_id,omitempty"`
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Text   string `json:"text"`
+	SentAt int64  `json:"sent_at,omitempty"`
 }
 
 // UserLeftData represents user left notification data
@@ -68,3 +249,27 @@ type UserLeftData struct {
 	UserID string   `json:"user_id"`
 	Users  []string `json:"users"`
 }
+
+// ServerDrainingData tells a client the server is shutting down. The client
+// should reconnect after ReconnectAfterMS milliseconds, which is spread out
+// across clients, and before the server closes the connection at Deadline
+// (Unix seconds). Reconnecting with the same session resumes its room.
+type ServerDrainingData struct {
+	ReconnectAfterMS int64  `json:"reconnect_after_ms"`
+	Deadline         int64  `json:"deadline"`
+	Message          string `json:"message"`
+}
+
+// SystemNoticeData is an operator announcement shown to everyone in a room
+type SystemNoticeData struct {
+	Message string `json:"message"`
+}
+
+// SessionResumedData tells a reconnecting client which user it was and which
+// room it is still a member of (empty if the grace period had expired)
+type SessionResumedData struct {
+	UserID   string        `json:"user_id"`
+	RoomID   string        `json:"room_id,omitempty"`
+	Users    []string      `json:"users,omitempty"`
+	Settings *RoomSettings `json:"settings,omitempty"`
+}
@@ -1,42 +1,215 @@
 package model
 
 import (
+	"fmt"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
-const MaxRoomUsers = 10
+// DefaultMaxParticipants is the room capacity used when neither the server
+// config nor the room sets one
+const DefaultMaxParticipants = 10
 
 // Room represents a signaling room
 type Room struct {
-	ID        string    `json:"id"`
-	Users     []string  `json:"users"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID       string       `json:"id"`
+	Users    []string     `json:"users"`
+	Policy   RoomPolicy   `json:"policy"`
+	Settings RoomSettings `json:"settings"`
+	// Publishers lists the members sending media, in the order they started
+	Publishers []string `json:"publishers,omitempty"`
+	// Roles holds members with more than participant rights, keyed by user ID
+	Roles     map[string]RoomRole `json:"roles,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// RoomRole is a member's moderation rank in a room
+type RoomRole string
+
+const (
+	// RoomRoleHost can moderate everyone, end the room and hand over hosting.
+	// A room has at most one host.
+	RoomRoleHost RoomRole = "host"
+	// RoomRoleModerator can mute-request, kick and ban participants
+	RoomRoleModerator   RoomRole = "moderator"
+	RoomRoleParticipant RoomRole = "participant"
+)
+
+var roleRanks = map[RoomRole]int{
+	RoomRoleParticipant: 0,
+	RoomRoleModerator:   1,
+	RoomRoleHost:        2,
+}
+
+// Outranks checks if a member with this role may moderate one with other
+func (r RoomRole) Outranks(other RoomRole) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+// CanModerate checks if the role may kick, ban and mute-request
+func (r RoomRole) CanModerate() bool {
+	return r == RoomRoleHost || r == RoomRoleModerator
+}
+
+// RoleOf returns the user's role in the room
+func (r *Room) RoleOf(userID string) RoomRole {
+	if role, ok := r.Roles[userID]; ok {
+		return role
+	}
+	return RoomRoleParticipant
+}
+
+// Host returns the ID of the room host, or "" if there is none
+func (r *Room) Host() string {
+	for userID, role := range r.Roles {
+		if role == RoomRoleHost {
+			return userID
+		}
+	}
+	return ""
+}
+
+// SetRole assigns role to the user. Making someone host demotes the previous
+// host to moderator.
+func (r *Room) SetRole(userID string, role RoomRole) {
+	if r.Roles == nil {
+		r.Roles = make(map[string]RoomRole)
+	}
+	if role == RoomRoleHost {
+		if host := r.Host(); host != "" && host != userID {
+			r.Roles[host] = RoomRoleModerator
+		}
+	}
+	if role == RoomRoleParticipant {
+		delete(r.Roles, userID)
+		return
+	}
+	r.Roles[userID] = role
+}
+
+// RoomSettings are a room's limits and features, fixed when it is created
+type RoomSettings struct {
+	MaxParticipants int `json:"max_participants"`
+	// MaxPublishers caps how many members may send media; 0 lets every member publish
+	MaxPublishers    int  `json:"max_publishers,omitempty"`
+	AllowScreenShare bool `json:"allow_screen_share"`
+	// E2EERequired admits only clients that support end-to-end encryption
+	E2EERequired bool `json:"e2ee_required,omitempty"`
+}
+
+// Capacity returns the most members the room may hold
+func (s RoomSettings) Capacity() int {
+	if s.MaxParticipants > 0 {
+		return s.MaxParticipants
+	}
+	return DefaultMaxParticipants
 }
 
-// CanJoin checks if a user can join the room
-func (r *Room) CanJoin() bool {
-	return len(r.Users) < MaxRoomUsers
+// RoomPolicy controls who may join a room. The owner is always admitted and
+// is the only user who can change the policy.
+type RoomPolicy struct {
+	Owner string `json:"owner,omitempty"`
+	// AllowedUsers and AllowedClaims form an allow-list; when both are empty
+	// anyone may join. AllowedClaims maps a claim name (e.g. "role") to the
+	// accepted values.
+	AllowedUsers  []string            `json:"allowed_users,omitempty"`
+	AllowedClaims map[string][]string `json:"allowed_claims,omitempty"`
+	// PasswordHash is a bcrypt hash, which carries its own salt
+	PasswordHash string `json:"password_hash,omitempty"`
+	Locked       bool   `json:"locked,omitempty"`
+	// LobbyEnabled holds new joiners in a lobby until a host or moderator admits them
+	LobbyEnabled bool `json:"lobby_enabled,omitempty"`
 }
 
-// AddUser adds a user to the room
-func (r *Room) AddUser(userID string) bool {
-	if !r.CanJoin() {
+// SetPassword stores a bcrypt hash of password; an empty password removes it
+func (p *RoomPolicy) SetPassword(password string) error {
+	if password == "" {
+		p.PasswordHash = ""
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash room password: %w", err)
+	}
+	p.PasswordHash = string(hash)
+	return nil
+}
+
+// HasPassword checks if the room requires a password
+func (p *RoomPolicy) HasPassword() bool {
+	return p.PasswordHash != ""
+}
+
+// CheckPassword checks password against the stored hash
+func (p *RoomPolicy) CheckPassword(password string) bool {
+	if !p.HasPassword() {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(p.PasswordHash), []byte(password)) == nil
+}
+
+// IsAllowed checks the user against the allow-list
+func (p *RoomPolicy) IsAllowed(userID string, identity *Identity) bool {
+	if len(p.AllowedUsers) == 0 && len(p.AllowedClaims) == 0 {
+		return true
+	}
+
+	for _, id := range p.AllowedUsers {
+		if id == userID {
+			return true
+		}
+	}
+
+	if identity == nil {
 		return false
 	}
-	
-	// Check if user is already in the room
-	for _, id := range r.Users {
+	for claim, values := range p.AllowedClaims {
+		actual := identity.Claims[claim]
+		for _, value := range values {
+			if actual != "" && actual == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsPublisher checks if the member is sending media
+func (r *Room) IsPublisher(userID string) bool {
+	for _, id := range r.Publishers {
 		if id == userID {
-			return true // User already in room
+			return true
 		}
 	}
-	
-	r.Users = append(r.Users, userID)
-	r.UpdatedAt = time.Now()
+	return false
+}
+
+// AddPublisher lets the member send media unless the room already has
+// MaxPublishers publishers
+func (r *Room) AddPublisher(userID string) bool {
+	if r.IsPublisher(userID) {
+		return true
+	}
+	if r.Settings.MaxPublishers > 0 && len(r.Publishers) >= r.Settings.MaxPublishers {
+		return false
+	}
+	r.Publishers = append(r.Publishers, userID)
 	return true
 }
 
+// RemovePublisher frees the member's publisher slot
+func (r *Room) RemovePublisher(userID string) {
+	for i, id := range r.Publishers {
+		if id == userID {
+			r.Publishers = append(r.Publishers[:i:i], r.Publishers[i+1:]...)
+			return
+		}
+	}
+}
+
 // RemoveUser removes a user from the room
 func (r *Room) RemoveUser(userID string) bool {
 	for i, id := range r.Users {
@@ -3,17 +3,47 @@ package model
 import (
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/signaling-server/internal/ratelimit"
+	"github.com/signaling-server/internal/wsconn"
 )
 
 // User represents a connected user
 type User struct {
-	ID         string          `json:"id"`
-	SessionID  string          `json:"session_id"`
-	RoomID     string          `json:"room_id,omitempty"`
-	Connection *websocket.Conn `json:"-"`
-	CreatedAt  time.Time       `json:"created_at"`
-	LastSeen   time.Time       `json:"last_seen"`
+	ID          string             `json:"id"`
+	SessionID   string             `json:"session_id"`
+	RoomID      string             `json:"room_id,omitempty"`
+	LobbyRoomID string             `json:"lobby_room_id,omitempty"`
+	DisplayName string             `json:"display_name,omitempty"`
+	RemoteIP    string             `json:"remote_ip,omitempty"`
+	Identity    *Identity          `json:"-"`
+	Connection  *wsconn.Conn       `json:"-"`
+	Limiter     *ratelimit.Limiter `json:"-"`
+	CreatedAt   time.Time          `json:"created_at"`
+	LastSeen    time.Time          `json:"last_seen"`
+}
+
+// Identity holds the verified token claims of an authenticated connection
+type Identity struct {
+	Subject     string   `json:"sub"`
+	DisplayName string   `json:"name,omitempty"`
+	Rooms       []string `json:"rooms,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	// Claims holds every other string-valued claim in the token
+	Claims map[string]string `json:"claims,omitempty"`
+}
+
+// CanAccessRoom checks if the identity's room claim allows roomID.
+// An identity without a room claim, or with "*", may join any room.
+func (i *Identity) CanAccessRoom(roomID string) bool {
+	if len(i.Rooms) == 0 {
+		return true
+	}
+	for _, room := range i.Rooms {
+		if room == "*" || room == roomID {
+			return true
+		}
+	}
+	return false
 }
 
 // UserSession represents user session data stored in Redis
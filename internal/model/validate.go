@@ -0,0 +1,304 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrorCode identifies why a client message was rejected. It is sent as
+// the reason of an error message.
+type ErrorCode string
+
+const (
+	// ErrorCodeInvalidMessage is a frame that is not a JSON message envelope
+	ErrorCodeInvalidMessage ErrorCode = "invalid_message"
+	ErrorCodeUnknownType    ErrorCode = "unknown_type"
+	// ErrorCodeInvalidPayload is data that does not decode into the payload of its type
+	ErrorCodeInvalidPayload   ErrorCode = "invalid_payload"
+	ErrorCodeMissingField     ErrorCode = "missing_field"
+	ErrorCodeInvalidRoomID    ErrorCode = "invalid_room_id"
+	ErrorCodeInvalidTarget    ErrorCode = "invalid_target"
+	ErrorCodeInvalidSDP       ErrorCode = "invalid_sdp"
+	ErrorCodeInvalidCandidate ErrorCode = "invalid_candidate"
+	ErrorCodeInvalidValue     ErrorCode = "invalid_value"
+	// ErrorCodeRateLimited is a message refused because the client exceeded a rate limit
+	ErrorCodeRateLimited ErrorCode = "rate_limited"
+)
+
+const (
+	// MaxRoomIDLength is the longest room ID accepted; room IDs may use
+	// letters, digits, '-', '_' and '.'
+	MaxRoomIDLength = 64
+	// MaxUserIDLength bounds user IDs named as targets
+	MaxUserIDLength = 128
+	// MaxRequestIDLength bounds the request_id clients attach to messages
+	MaxRequestIDLength = 64
+	// MaxChatMessageLength bounds the text of a chat message, in bytes
+	MaxChatMessageLength = 4096
+	// MaxPasswordLength is the longest room password, in bytes, that bcrypt
+	// can hash
+	MaxPasswordLength = 72
+)
+
+// ValidationError is a client message that failed validation
+type ValidationError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func invalid(code ErrorCode, format string, args ...any) *ValidationError {
+	return &ValidationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Payload is the data of a client message
+type Payload interface {
+	Validate() error
+}
+
+// clientPayloads creates the payload of each client message type; types
+// mapped to nil carry no data
+var clientPayloads = map[MessageType]func() Payload{
+	MessageTypeJoinRoom:         func() Payload { return &JoinRoomData{} },
+	MessageTypeCreateRoom:       func() Payload { return &CreateRoomData{} },
+	MessageTypeLeaveRoom:        nil,
+	MessageTypeOffer:            func() Payload { return &OfferData{} },
+	MessageTypeAnswer:           func() Payload { return &AnswerData{} },
+	MessageTypeIceCandidate:     func() Payload { return &IceCandidateData{} },
+	MessageTypeUpdateRoomPolicy: func() Payload { return &RoomPolicyUpdateData{} },
+	MessageTypeKickUser:         func() Payload { return &ModerationData{} },
+	MessageTypeBanUser:          func() Payload { return &ModerationData{} },
+	MessageTypeEndRoom:          nil,
+	MessageTypeTransferHost:     func() Payload { return &ModerationData{} },
+	MessageTypeRequestMute:      func() Payload { return &ModerationData{} },
+	MessageTypeAdmit:            func() Payload { return &ModerationData{} },
+	MessageTypeDeny:             func() Payload { return &ModerationData{} },
+	MessageTypeChatMessage:      func() Payload { return &ChatMessageData{} },
+}
+
+// IsClientMessageType reports whether clients may send messages of type t
+func IsClientMessageType(t MessageType) bool {
+	_, ok := clientPayloads[t]
+	return ok
+}
+
+// ValidateMessage checks a client message: its type must be one clients may
+// send, peer-to-peer messages must name a target and the data must decode
+// into the type's payload and pass its checks. It returns the decoded
+// payload, nil for types without data, or a *ValidationError.
+func ValidateMessage(msg *Message) (Payload, error) {
+	if len(msg.RequestID) > MaxRequestIDLength {
+		return nil, invalid(ErrorCodeInvalidValue, "request_id is longer than %d characters", MaxRequestIDLength)
+	}
+
+	newPayload, ok := clientPayloads[msg.Type]
+	if !ok {
+		return nil, invalid(ErrorCodeUnknownType, "unknown message type %q", msg.Type)
+	}
+
+	switch msg.Type {
+	case MessageTypeOffer, MessageTypeAnswer, MessageTypeIceCandidate:
+		if err := validateUserID(msg.TargetID, ErrorCodeInvalidTarget, "target_id"); err != nil {
+			return nil, err
+		}
+	case MessageTypeChatMessage:
+		if len(msg.TargetID) > MaxUserIDLength {
+			return nil, invalid(ErrorCodeInvalidTarget, "target_id is longer than %d characters", MaxUserIDLength)
+		}
+	}
+
+	if newPayload == nil {
+		return nil, nil
+	}
+	payload := newPayload()
+	if err := DecodeData(msg.Data, payload); err != nil {
+		return nil, invalid(ErrorCodeInvalidPayload, "invalid %s data: %v", msg.Type, err)
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// DecodeData unmarshals message data into v. Data may be a JSON object or,
+// as the bundled client sends offers, answers and candidates, a string
+// holding one.
+func DecodeData(data json.RawMessage, v any) error {
+	if len(data) > 0 && data[0] == '"' {
+		var encoded string
+		if err := json.Unmarshal(data, &encoded); err != nil {
+			return err
+		}
+		data = json.RawMessage(encoded)
+	}
+	if len(data) == 0 {
+		data = json.RawMessage("{}")
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ValidateRoomID checks a room ID's length and charset
+func ValidateRoomID(roomID string) error {
+	if roomID == "" {
+		return invalid(ErrorCodeMissingField, "room_id is required")
+	}
+	if len(roomID) > MaxRoomIDLength {
+		return invalid(ErrorCodeInvalidRoomID, "room_id is longer than %d characters", MaxRoomIDLength)
+	}
+	for _, c := range roomID {
+		if !isRoomIDChar(c) {
+			return invalid(ErrorCodeInvalidRoomID, "room_id may only contain letters, digits, '-', '_' and '.'")
+		}
+	}
+	return nil
+}
+
+func isRoomIDChar(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.'
+}
+
+func validateUserID(userID string, code ErrorCode, field string) error {
+	if userID == "" {
+		return invalid(ErrorCodeMissingField, "%s is required", field)
+	}
+	if len(userID) > MaxUserIDLength {
+		return invalid(code, "%s is longer than %d characters", field, MaxUserIDLength)
+	}
+	return nil
+}
+
+// Validate checks the room ID
+func (d *JoinRoomData) Validate() error {
+	return ValidateRoomID(d.RoomID)
+}
+
+// ValidatePassword checks the length of a new room password
+func ValidatePassword(password string) error {
+	if len(password) > MaxPasswordLength {
+		return invalid(ErrorCodeInvalidValue, "password is longer than %d bytes", MaxPasswordLength)
+	}
+	return nil
+}
+
+// Validate checks the room ID, the password and that no setting is
+// negative; ranges that depend on the server config are checked when the
+// room is created
+func (d *CreateRoomData) Validate() error {
+	if err := d.JoinRoomData.Validate(); err != nil {
+		return err
+	}
+	if err := ValidatePassword(d.Password); err != nil {
+		return err
+	}
+	if d.Settings.MaxParticipants != nil && *d.Settings.MaxParticipants < 0 ||
+		d.Settings.MaxPublishers != nil && *d.Settings.MaxPublishers < 0 {
+		return invalid(ErrorCodeInvalidValue, "room settings may not be negative")
+	}
+	return nil
+}
+
+// Validate checks that the description is an SDP offer
+func (d *OfferData) Validate() error {
+	return validateSDP(d.Type, d.SDP, "offer")
+}
+
+// Validate checks that the description is an SDP answer or provisional answer
+func (d *AnswerData) Validate() error {
+	return validateSDP(d.Type, d.SDP, "answer", "pranswer")
+}
+
+func validateSDP(sdpType, sdp string, allowed ...string) error {
+	typeOK := false
+	for _, t := range allowed {
+		typeOK = typeOK || sdpType == t
+	}
+	if !typeOK {
+		return invalid(ErrorCodeInvalidSDP, "description type %q does not match the message type", sdpType)
+	}
+	if !strings.HasPrefix(sdp, "v=0") {
+		return invalid(ErrorCodeInvalidSDP, "sdp must be a session description starting with v=0")
+	}
+	return nil
+}
+
+// iceCandidateTypes are the candidate types of RFC 8445
+var iceCandidateTypes = map[string]bool{"host": true, "srflx": true, "prflx": true, "relay": true}
+
+// Validate checks the candidate attribute against the RFC 8839 grammar:
+//
+//	candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+//
+// An empty candidate marks the end of candidates and is accepted.
+func (d *IceCandidateData) Validate() error {
+	if d.SDPMLineIndex < 0 {
+		return invalid(ErrorCodeInvalidCandidate, "sdpMLineIndex may not be negative")
+	}
+	if d.Candidate == "" {
+		return nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(d.Candidate, "candidate:"))
+	if !strings.HasPrefix(d.Candidate, "candidate:") || len(fields) < 8 || fields[6] != "typ" {
+		return invalid(ErrorCodeInvalidCandidate, "candidate is not a valid ICE candidate attribute")
+	}
+	if component, err := strconv.Atoi(fields[1]); err != nil || component < 1 || component > 256 {
+		return invalid(ErrorCodeInvalidCandidate, "invalid candidate component %q", fields[1])
+	}
+	if transport := strings.ToLower(fields[2]); transport != "udp" && transport != "tcp" {
+		return invalid(ErrorCodeInvalidCandidate, "invalid candidate transport %q", fields[2])
+	}
+	if _, err := strconv.ParseUint(fields[3], 10, 32); err != nil {
+		return invalid(ErrorCodeInvalidCandidate, "invalid candidate priority %q", fields[3])
+	}
+	if port, err := strconv.Atoi(fields[5]); err != nil || port < 0 || port > 65535 {
+		return invalid(ErrorCodeInvalidCandidate, "invalid candidate port %q", fields[5])
+	}
+	if !iceCandidateTypes[fields[7]] {
+		return invalid(ErrorCodeInvalidCandidate, "invalid candidate type %q", fields[7])
+	}
+	return nil
+}
+
+// Validate checks a new password's length and that a new owner, if given,
+// is named
+func (d *RoomPolicyUpdateData) Validate() error {
+	if d.Password != nil {
+		if err := ValidatePassword(*d.Password); err != nil {
+			return err
+		}
+	}
+	if d.Owner != nil {
+		return validateUserID(*d.Owner, ErrorCodeInvalidValue, "owner")
+	}
+	return nil
+}
+
+// Validate checks the target user, ban duration and mute kind
+func (d *ModerationData) Validate() error {
+	if err := validateUserID(d.UserID, ErrorCodeInvalidTarget, "user_id"); err != nil {
+		return err
+	}
+	if d.Duration < 0 {
+		return invalid(ErrorCodeInvalidValue, "duration may not be negative")
+	}
+	if d.Kind != "" && d.Kind != "audio" && d.Kind != "video" {
+		return invalid(ErrorCodeInvalidValue, "kind must be audio or video")
+	}
+	return nil
+}
+
+// Validate checks that the text is not blank and within the length limit
+func (d *ChatMessageData) Validate() error {
+	if strings.TrimSpace(d.Text) == "" {
+		return invalid(ErrorCodeMissingField, "text is required")
+	}
+	if len(d.Text) > MaxChatMessageLength {
+		return invalid(ErrorCodeInvalidValue, "text is longer than %d bytes", MaxChatMessageLength)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Package ratelimit limits how fast a single connection may send messages
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket refilled at Rate tokens per second that holds at
+// most Burst tokens. A zero Rate disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Config configures a connection's limiter. Keys without an entry in Keys
+// use Default. A connection that is refused more than MaxViolations times
+// within ViolationWindow is reported as abusive; zero MaxViolations never
+// reports one.
+type Config struct {
+	Default         Limit
+	Keys            map[string]Limit
+	MaxViolations   int
+	ViolationWindow time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter holds a token bucket per key, such as a message type, for one
+// connection. It is safe for concurrent use.
+type Limiter struct {
+	config Config
+
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	violations  int
+	windowStart time.Time
+}
+
+// New creates a limiter whose buckets start full
+func New(config Config) *Limiter {
+	if config.ViolationWindow <= 0 {
+		config.ViolationWindow = time.Minute
+	}
+	return &Limiter{
+		config:  config,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// SetConfig replaces the limits. Buckets keep their tokens, capped at the
+// new burst size on their next use.
+func (l *Limiter) SetConfig(config Config) {
+	if config.ViolationWindow <= 0 {
+		config.ViolationWindow = time.Minute
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+}
+
+// Allow takes a token from key's bucket and reports whether there was one
+func (l *Limiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit, ok := l.config.Keys[key]
+	if !ok {
+		limit = l.config.Default
+	}
+	if limit.Rate <= 0 {
+		return true
+	}
+	burst := float64(max(limit.Burst, 1))
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Violation records a refused request and reports whether the connection
+// has now exceeded MaxViolations within the current window
+func (l *Limiter) Violation() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) > l.config.ViolationWindow {
+		l.windowStart, l.violations = now, 0
+	}
+	l.violations++
+	return l.config.MaxViolations > 0 && l.violations > l.config.MaxViolations
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// roomCursor marks a position in the room index, which is ordered by last
+// activity, newest first, with ties broken by room ID in descending order.
+// Pages start strictly after the cursor.
+type roomCursor struct {
+	activity int64 // Unix milliseconds
+	roomID   string
+}
+
+// encodeRoomCursor returns an opaque cursor pointing after the given room
+func encodeRoomCursor(activity int64, roomID string) string {
+	raw := strconv.FormatInt(activity, 10) + ":" + roomID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeRoomCursor parses a cursor; "" is the start of the index
+func decodeRoomCursor(cursor string) (*roomCursor, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	activity, roomID, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	ms, err := strconv.ParseInt(activity, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &roomCursor{activity: ms, roomID: roomID}, nil
+}
+
+// after reports whether a room with the given activity comes after the cursor
+func (c *roomCursor) after(activity int64, roomID string) bool {
+	if c == nil {
+		return true
+	}
+	return activity < c.activity || (activity == c.activity && roomID < c.roomID)
+}
@@ -0,0 +1,14 @@
+package repository
+
+import "errors"
+
+var (
+	// ErrRoomFull is returned when a user tries to join a room that is at capacity
+	ErrRoomFull = errors.New("room is full")
+	// ErrRoomExists is returned when creating a room whose ID is taken
+	ErrRoomExists = errors.New("room already exists")
+	// ErrRoomNotFound is returned when updating a room that does not exist
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrInvalidCursor is returned when a ListRooms cursor cannot be parsed
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
@@ -2,30 +2,105 @@ package repository
 
 import (
 	"context"
+	"time"
+
 	"github.com/signaling-server/internal/model"
 )
 
-// UserRepository defines the interface for user data operations
+// User defines the interface for user data operations
 type User interface {
 	SaveUser(ctx context.Context, user *model.UserSession) error
 	GetUser(ctx context.Context, userID string) (*model.UserSession, error)
+	GetUserBySession(ctx context.Context, sessionID string) (*model.UserSession, error)
 	DeleteUser(ctx context.Context, userID string) error
 	UpdateUserRoom(ctx context.Context, userID, roomID string) error
 }
 
-// RoomRepository defines the interface for room data operations
+// Room defines the interface for room data operations
 type Room interface {
-	SaveRoom(ctx context.Context, room *model.Room) error
+	// CreateRoom stores a new room without members. It returns ErrRoomExists
+	// if the ID is taken.
+	CreateRoom(ctx context.Context, room *model.Room) error
 	GetRoom(ctx context.Context, roomID string) (*model.Room, error)
 	DeleteRoom(ctx context.Context, roomID string) error
-	AddUserToRoom(ctx context.Context, roomID, userID string) error
+	// AddUserToRoom adds a member if the room is below the capacity in its
+	// settings and returns ErrRoomFull otherwise. If the room does not exist
+	// yet it is created from initial, or ErrRoomNotFound is returned if
+	// initial is nil.
+	AddUserToRoom(ctx context.Context, roomID, userID string, initial *model.Room) error
 	RemoveUserFromRoom(ctx context.Context, roomID, userID string) error
 	GetRoomUsers(ctx context.Context, roomID string) ([]string, error)
+	// ListRooms returns up to limit rooms with their members, most recently
+	// active first, starting after cursor ("" for the first page). It also
+	// returns the cursor of the next page, or "" after the last page, and
+	// ErrInvalidCursor if cursor was not returned by ListRooms.
+	ListRooms(ctx context.Context, cursor string, limit int) ([]*model.Room, string, error)
+	// CountRooms returns the number of live rooms
+	CountRooms(ctx context.Context) (int, error)
+	// UpdateRoom applies update to the room metadata atomically and returns the
+	// stored room. It returns ErrRoomNotFound if the room does not exist and
+	// any error returned by update unchanged.
+	UpdateRoom(ctx context.Context, roomID string, update func(room *model.Room) error) (*model.Room, error)
+	// BanUser stops the user from joining the room for duration. Bans are
+	// kept independently of the room so they survive it emptying out.
+	BanUser(ctx context.Context, roomID, userID string, duration time.Duration) error
+	IsBanned(ctx context.Context, roomID, userID string) (bool, error)
+	// The lobby holds users waiting to be admitted, ordered by arrival.
+	// RemoveFromLobby reports whether the user was waiting.
+	AddToLobby(ctx context.Context, roomID, userID string) error
+	RemoveFromLobby(ctx context.Context, roomID, userID string) (bool, error)
+	GetLobby(ctx context.Context, roomID string) ([]string, error)
+	// AppendChatMessage adds a message to the room's chat history, keeping
+	// only the newest limit messages. It returns ErrRoomNotFound if the room
+	// does not exist; the history is deleted with the room.
+	AppendChatMessage(ctx context.Context, roomID string, message *model.ChatMessageData, limit int) error
+	// GetChatHistory returns the room's chat history, oldest first
+	GetChatHistory(ctx context.Context, roomID string) ([]model.ChatMessageData, error)
 }
 
-// PubSubRepository defines the interface for pub/sub operations
+// PubSub defines the interface for pub/sub operations.
+// Subscriptions end, and their channels are closed, when the ctx passed to
+// Subscribe/PSubscribe is cancelled or Unsubscribe is called for the same
+// channel or pattern.
 type PubSub interface {
 	Publish(ctx context.Context, channel string, message []byte) error
 	Subscribe(ctx context.Context, channel string) (<-chan []byte, error)
+	PSubscribe(ctx context.Context, pattern string) (<-chan Message, error)
 	Unsubscribe(ctx context.Context, channel string) error
+	// OnReconnect registers a handler called with the channel or pattern
+	// each time a subscription is re-established after a connection loss
+	OnReconnect(handler func(channel string))
+	// Subscribed reports whether a subscription on channel or pattern is active
+	Subscribed(channel string) bool
+}
+
+// RateLimit keeps the counters behind limits that must hold across
+// every node
+type RateLimit interface {
+	// IncrementCounter adds one to key's counter for the current fixed window
+	// of the given length and returns the new count
+	IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error)
+	// AcquireSlot takes one of limit slots under key for holder until ttl
+	// passes or ReleaseSlot is called. Acquiring a slot already held renews
+	// it. It reports false if every slot is taken.
+	AcquireSlot(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error)
+	ReleaseSlot(ctx context.Context, key, holder string) error
+}
+
+// Pinger checks that the backing store is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Message is a pub/sub message received through a pattern subscription
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// Presence tracks which signaling node each connected user is attached to
+type Presence interface {
+	SetUserNode(ctx context.Context, userID, nodeID string, ttl time.Duration) error
+	GetUserNode(ctx context.Context, userID string) (string, error)
+	RemoveUserNode(ctx context.Context, userID, nodeID string) error
 }
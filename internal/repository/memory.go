@@ -0,0 +1,794 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/signaling-server/internal/model"
+)
+
+// MemoryRepository is an in-process implementation of the repository
+// interfaces for single-node deployments and tests. Entries honour the same
+// TTLs as the Redis implementation and pub/sub fans out to every subscriber.
+type MemoryRepository struct {
+	mu       sync.RWMutex
+	users    map[string]memoryEntry[model.UserSession]
+	sessions map[string]memoryEntry[string]
+	rooms    map[string]memoryEntry[memoryRoom]
+	presence map[string]memoryEntry[string]
+	bans     map[memoryBan]memoryEntry[struct{}]
+	lobbies  map[string]memoryEntry[[]string]
+	chats    map[string][]model.ChatMessageData
+	counters map[string]memoryEntry[int64]
+	// slots maps a slot key to the time each holder's slot lapses
+	slots map[string]map[string]time.Time
+
+	subMu       sync.RWMutex
+	subscribers map[string]map[*memorySubscriber]struct{}
+	patterns    map[string]map[*memorySubscriber]struct{}
+
+	done chan struct{}
+	once sync.Once
+}
+
+type memoryEntry[T any] struct {
+	value     T
+	expiresAt time.Time
+}
+
+func (e memoryEntry[T]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+type memoryBan struct {
+	roomID string
+	userID string
+}
+
+type memoryRoom struct {
+	room  model.Room
+	users []string
+	// activity is the last membership or metadata change, which orders ListRooms
+	activity time.Time
+}
+
+// memorySubscriber receives either raw payloads (Subscribe) or messages
+// tagged with their channel (PSubscribe)
+type memorySubscriber struct {
+	payloads chan []byte
+	messages chan Message
+	stop     chan struct{}
+	closed   bool
+}
+
+// NewMemoryRepository creates an in-memory repository and starts a janitor
+// that evicts expired entries. Call Close to stop it.
+func NewMemoryRepository() *MemoryRepository {
+	r := &MemoryRepository{
+		users:       make(map[string]memoryEntry[model.UserSession]),
+		sessions:    make(map[string]memoryEntry[string]),
+		rooms:       make(map[string]memoryEntry[memoryRoom]),
+		presence:    make(map[string]memoryEntry[string]),
+		bans:        make(map[memoryBan]memoryEntry[struct{}]),
+		lobbies:     make(map[string]memoryEntry[[]string]),
+		chats:       make(map[string][]model.ChatMessageData),
+		counters:    make(map[string]memoryEntry[int64]),
+		slots:       make(map[string]map[string]time.Time),
+		subscribers: make(map[string]map[*memorySubscriber]struct{}),
+		patterns:    make(map[string]map[*memorySubscriber]struct{}),
+		done:        make(chan struct{}),
+	}
+
+	go r.janitor(time.Minute)
+
+	return r
+}
+
+// Close stops the janitor and closes all subscriptions
+func (r *MemoryRepository) Close() error {
+	r.once.Do(func() {
+		close(r.done)
+
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+		for _, registry := range []map[string]map[*memorySubscriber]struct{}{r.subscribers, r.patterns} {
+			for channel, subs := range registry {
+				for sub := range subs {
+					sub.close()
+				}
+				delete(registry, channel)
+			}
+		}
+	})
+	return nil
+}
+
+func (r *MemoryRepository) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			r.mu.Lock()
+			for id, entry := range r.users {
+				if entry.expired(now) {
+					delete(r.users, id)
+				}
+			}
+			for id, entry := range r.sessions {
+				if entry.expired(now) {
+					delete(r.sessions, id)
+				}
+			}
+			for id, entry := range r.rooms {
+				if entry.expired(now) {
+					delete(r.rooms, id)
+				}
+			}
+			for id := range r.chats {
+				if _, ok := r.rooms[id]; !ok {
+					delete(r.chats, id)
+				}
+			}
+			for id, entry := range r.presence {
+				if entry.expired(now) {
+					delete(r.presence, id)
+				}
+			}
+			for ban, entry := range r.bans {
+				if entry.expired(now) {
+					delete(r.bans, ban)
+				}
+			}
+			for id, entry := range r.lobbies {
+				if entry.expired(now) {
+					delete(r.lobbies, id)
+				}
+			}
+			for key, entry := range r.counters {
+				if entry.expired(now) {
+					delete(r.counters, key)
+				}
+			}
+			for key := range r.slots {
+				r.pruneSlots(key, now)
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// User repository implementation
+func (r *MemoryRepository) SaveUser(ctx context.Context, user *model.UserSession) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	r.users[user.ID] = memoryEntry[model.UserSession]{value: *user, expiresAt: expiresAt}
+	if user.SessionID != "" {
+		r.sessions[user.SessionID] = memoryEntry[string]{value: user.ID, expiresAt: expiresAt}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetUser(ctx context.Context, userID string) (*model.UserSession, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.users[userID]
+	if !ok || entry.expired(time.Now()) {
+		return nil, nil
+	}
+
+	user := entry.value
+	return &user, nil
+}
+
+// GetUserBySession returns the user most recently bound to sessionID, or nil
+func (r *MemoryRepository) GetUserBySession(ctx context.Context, sessionID string) (*model.UserSession, error) {
+	r.mu.RLock()
+	entry, ok := r.sessions[sessionID]
+	r.mu.RUnlock()
+
+	if !ok || entry.expired(time.Now()) {
+		return nil, nil
+	}
+	return r.GetUser(ctx, entry.value)
+}
+
+func (r *MemoryRepository) DeleteUser(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.users[userID]; ok {
+		if session, ok := r.sessions[entry.value.SessionID]; ok && session.value == userID {
+			delete(r.sessions, entry.value.SessionID)
+		}
+	}
+	delete(r.users, userID)
+	return nil
+}
+
+func (r *MemoryRepository) UpdateUserRoom(ctx context.Context, userID, roomID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.users[userID]
+	if !ok || entry.expired(time.Now()) {
+		return fmt.Errorf("user not found: %s", userID)
+	}
+
+	entry.value.RoomID = roomID
+	entry.value.LastSeen = time.Now()
+	entry.expiresAt = time.Now().Add(24 * time.Hour)
+	r.users[userID] = entry
+	if entry.value.SessionID != "" {
+		r.sessions[entry.value.SessionID] = memoryEntry[string]{value: userID, expiresAt: entry.expiresAt}
+	}
+	return nil
+}
+
+// Room repository implementation
+
+// CreateRoom stores a room that has no members yet
+func (r *MemoryRepository) CreateRoom(ctx context.Context, room *model.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := r.rooms[room.ID]; ok && !entry.expired(now) {
+		return ErrRoomExists
+	}
+
+	meta := cloneRoom(*room)
+	meta.Users = nil
+	r.rooms[room.ID] = memoryEntry[memoryRoom]{
+		value:     memoryRoom{room: meta, activity: now},
+		expiresAt: now.Add(roomTTL),
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetRoom(ctx context.Context, roomID string) (*model.Room, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.rooms[roomID]
+	if !ok || entry.expired(time.Now()) {
+		return nil, nil
+	}
+
+	room := cloneRoom(entry.value.room)
+	room.Users = append([]string{}, entry.value.users...)
+	return &room, nil
+}
+
+func (r *MemoryRepository) DeleteRoom(ctx context.Context, roomID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.rooms, roomID)
+	delete(r.chats, roomID)
+	delete(r.lobbies, roomID)
+	return nil
+}
+
+// AddUserToRoom adds a user to the room, creating it from initial if needed.
+// It returns ErrRoomFull when the room is already at capacity.
+func (r *MemoryRepository) AddUserToRoom(ctx context.Context, roomID, userID string, initial *model.Room) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := r.rooms[roomID]
+	if !ok || entry.expired(now) {
+		if initial == nil {
+			return ErrRoomNotFound
+		}
+		meta := cloneRoom(*initial)
+		meta.Users = nil
+		entry = memoryEntry[memoryRoom]{value: memoryRoom{room: meta}}
+		delete(r.chats, roomID)
+	}
+
+	for _, id := range entry.value.users {
+		if id == userID {
+			return nil
+		}
+	}
+	if len(entry.value.users) >= entry.value.room.Settings.Capacity() {
+		return ErrRoomFull
+	}
+
+	entry.value.users = append(entry.value.users, userID)
+	entry.value.activity = now
+	entry.expiresAt = now.Add(roomTTL)
+	r.rooms[roomID] = entry
+	return nil
+}
+
+// RemoveUserFromRoom removes a user and deletes the room once its last
+// member leaves. Removing a non-member leaves the room alone.
+func (r *MemoryRepository) RemoveUserFromRoom(ctx context.Context, roomID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.rooms[roomID]
+	if !ok {
+		return nil
+	}
+
+	users := entry.value.users[:0:0]
+	for _, id := range entry.value.users {
+		if id != userID {
+			users = append(users, id)
+		}
+	}
+	if len(users) == len(entry.value.users) {
+		return nil
+	}
+
+	if len(users) == 0 {
+		delete(r.rooms, roomID)
+		delete(r.chats, roomID)
+		delete(r.lobbies, roomID)
+		return nil
+	}
+
+	entry.value.activity = time.Now()
+	entry.expiresAt = entry.value.activity.Add(roomTTL)
+	entry.value.users = users
+	r.rooms[roomID] = entry
+	return nil
+}
+
+func (r *MemoryRepository) GetRoomUsers(ctx context.Context, roomID string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.rooms[roomID]
+	if !ok || entry.expired(time.Now()) {
+		return []string{}, nil
+	}
+
+	return append([]string{}, entry.value.users...), nil
+}
+
+// ListRooms sorts the live rooms by last activity and returns a page of them
+func (r *MemoryRepository) ListRooms(ctx context.Context, cursor string, limit int) ([]*model.Room, string, error) {
+	after, err := decodeRoomCursor(cursor)
+	if err != nil || limit <= 0 {
+		return nil, "", err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var live []memoryRoom
+	for _, entry := range r.rooms {
+		if !entry.expired(now) && after.after(entry.value.activity.UnixMilli(), entry.value.room.ID) {
+			live = append(live, entry.value)
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		ai, aj := live[i].activity.UnixMilli(), live[j].activity.UnixMilli()
+		if ai != aj {
+			return ai > aj
+		}
+		return live[i].room.ID > live[j].room.ID
+	})
+
+	next := ""
+	if len(live) > limit {
+		live = live[:limit]
+		last := live[len(live)-1]
+		next = encodeRoomCursor(last.activity.UnixMilli(), last.room.ID)
+	}
+
+	rooms := make([]*model.Room, 0, len(live))
+	for _, value := range live {
+		room := cloneRoom(value.room)
+		room.Users = append([]string{}, value.users...)
+		rooms = append(rooms, &room)
+	}
+	return rooms, next, nil
+}
+
+func (r *MemoryRepository) CountRooms(ctx context.Context) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	count := 0
+	for _, entry := range r.rooms {
+		if !entry.expired(now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// UpdateRoom applies update under the repository lock
+func (r *MemoryRepository) UpdateRoom(ctx context.Context, roomID string, update func(room *model.Room) error) (*model.Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.rooms[roomID]
+	if !ok || entry.expired(time.Now()) {
+		return nil, ErrRoomNotFound
+	}
+
+	room := cloneRoom(entry.value.room)
+	room.Users = append([]string{}, entry.value.users...)
+	if err := update(&room); err != nil {
+		return nil, err
+	}
+	room.UpdatedAt = time.Now()
+
+	entry.value.room = cloneRoom(room)
+	entry.value.room.Users = nil
+	entry.value.activity = room.UpdatedAt
+	entry.expiresAt = room.UpdatedAt.Add(roomTTL)
+	r.rooms[roomID] = entry
+	return &room, nil
+}
+
+// cloneRoom copies the maps and slices of a room's metadata so callers
+// never share them with the stored entry
+func cloneRoom(room model.Room) model.Room {
+	if room.Roles != nil {
+		roles := make(map[string]model.RoomRole, len(room.Roles))
+		for userID, role := range room.Roles {
+			roles[userID] = role
+		}
+		room.Roles = roles
+	}
+	room.Publishers = append([]string(nil), room.Publishers...)
+	room.Policy.AllowedUsers = append([]string(nil), room.Policy.AllowedUsers...)
+	if room.Policy.AllowedClaims != nil {
+		claims := make(map[string][]string, len(room.Policy.AllowedClaims))
+		for claim, values := range room.Policy.AllowedClaims {
+			claims[claim] = append([]string(nil), values...)
+		}
+		room.Policy.AllowedClaims = claims
+	}
+	return room
+}
+
+// BanUser records a ban until now+duration, capped at the room lifetime
+func (r *MemoryRepository) BanUser(ctx context.Context, roomID, userID string, duration time.Duration) error {
+	if duration <= 0 || duration > roomTTL {
+		duration = roomTTL
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bans[memoryBan{roomID: roomID, userID: userID}] = memoryEntry[struct{}]{expiresAt: time.Now().Add(duration)}
+	return nil
+}
+
+func (r *MemoryRepository) IsBanned(ctx context.Context, roomID, userID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.bans[memoryBan{roomID: roomID, userID: userID}]
+	return ok && !entry.expired(time.Now()), nil
+}
+
+func (r *MemoryRepository) AddToLobby(ctx context.Context, roomID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := r.lobbies[roomID]
+	if entry.expired(time.Now()) {
+		entry.value = nil
+	}
+	for _, id := range entry.value {
+		if id == userID {
+			return nil
+		}
+	}
+
+	entry.value = append(entry.value, userID)
+	entry.expiresAt = time.Now().Add(roomTTL)
+	r.lobbies[roomID] = entry
+	return nil
+}
+
+func (r *MemoryRepository) RemoveFromLobby(ctx context.Context, roomID, userID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.lobbies[roomID]
+	if !ok || entry.expired(time.Now()) {
+		return false, nil
+	}
+
+	removed := false
+	waiting := entry.value[:0:0]
+	for _, id := range entry.value {
+		if id == userID {
+			removed = true
+			continue
+		}
+		waiting = append(waiting, id)
+	}
+
+	if len(waiting) == 0 {
+		delete(r.lobbies, roomID)
+	} else {
+		entry.value = waiting
+		r.lobbies[roomID] = entry
+	}
+	return removed, nil
+}
+
+func (r *MemoryRepository) GetLobby(ctx context.Context, roomID string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.lobbies[roomID]
+	if !ok || entry.expired(time.Now()) {
+		return []string{}, nil
+	}
+
+	return append([]string{}, entry.value...), nil
+}
+
+// AppendChatMessage keeps the newest limit messages of a live room
+func (r *MemoryRepository) AppendChatMessage(ctx context.Context, roomID string, message *model.ChatMessageData, limit int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.rooms[roomID]; !ok || entry.expired(time.Now()) {
+		return ErrRoomNotFound
+	}
+
+	history := append(r.chats[roomID], *message)
+	if len(history) > limit {
+		history = append([]model.ChatMessageData(nil), history[len(history)-limit:]...)
+	}
+	r.chats[roomID] = history
+	return nil
+}
+
+func (r *MemoryRepository) GetChatHistory(ctx context.Context, roomID string) ([]model.ChatMessageData, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if entry, ok := r.rooms[roomID]; !ok || entry.expired(time.Now()) {
+		return []model.ChatMessageData{}, nil
+	}
+
+	return append([]model.ChatMessageData{}, r.chats[roomID]...), nil
+}
+
+// Presence repository implementation
+func (r *MemoryRepository) SetUserNode(ctx context.Context, userID, nodeID string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry := memoryEntry[string]{value: nodeID}
+	if ttl > 0 {
+		entry.expiresAt = time.Now().Add(ttl)
+	}
+	r.presence[userID] = entry
+	return nil
+}
+
+func (r *MemoryRepository) GetUserNode(ctx context.Context, userID string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.presence[userID]
+	if !ok || entry.expired(time.Now()) {
+		return "", nil
+	}
+	return entry.value, nil
+}
+
+func (r *MemoryRepository) RemoveUserNode(ctx context.Context, userID, nodeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.presence[userID]; ok && entry.value == nodeID {
+		delete(r.presence, userID)
+	}
+	return nil
+}
+
+// Rate limit repository implementation
+func (r *MemoryRepository) IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	start := time.Now().Truncate(window)
+	counterKey := fmt.Sprintf("%s:%d", key, start.Unix())
+	entry := r.counters[counterKey]
+	entry.value++
+	entry.expiresAt = start.Add(window)
+	r.counters[counterKey] = entry
+	return entry.value, nil
+}
+
+func (r *MemoryRepository) AcquireSlot(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.pruneSlots(key, now)
+	holders := r.slots[key]
+	if _, held := holders[holder]; !held && len(holders) >= limit {
+		return false, nil
+	}
+
+	if holders == nil {
+		holders = make(map[string]time.Time)
+		r.slots[key] = holders
+	}
+	holders[holder] = now.Add(ttl)
+	return true, nil
+}
+
+func (r *MemoryRepository) ReleaseSlot(ctx context.Context, key, holder string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.slots[key], holder)
+	if len(r.slots[key]) == 0 {
+		delete(r.slots, key)
+	}
+	return nil
+}
+
+// pruneSlots must be called with mu held
+func (r *MemoryRepository) pruneSlots(key string, now time.Time) {
+	for holder, lapses := range r.slots[key] {
+		if now.After(lapses) {
+			delete(r.slots[key], holder)
+		}
+	}
+	if len(r.slots[key]) == 0 {
+		delete(r.slots, key)
+	}
+}
+
+// PubSub repository implementation
+func (r *MemoryRepository) Publish(ctx context.Context, channel string, message []byte) error {
+	r.subMu.RLock()
+	defer r.subMu.RUnlock()
+
+	for sub := range r.subscribers[channel] {
+		sub.deliver(channel, message)
+	}
+	for pattern, subs := range r.patterns {
+		if matched, _ := path.Match(pattern, channel); !matched {
+			continue
+		}
+		for sub := range subs {
+			sub.deliver(channel, message)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a subscriber on channel. The returned channel is closed
+// when ctx is cancelled, Unsubscribe is called for the channel or the
+// repository is closed.
+func (r *MemoryRepository) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
+	sub := &memorySubscriber{payloads: make(chan []byte, 100), stop: make(chan struct{})}
+	r.addSubscriber(ctx, r.subscribers, channel, sub)
+	return sub.payloads, nil
+}
+
+// PSubscribe subscribes to every channel matching a glob pattern.
+// Patterns follow path.Match syntax, which agrees with Redis globs for
+// channel names without '/'.
+func (r *MemoryRepository) PSubscribe(ctx context.Context, pattern string) (<-chan Message, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+
+	sub := &memorySubscriber{messages: make(chan Message, 100), stop: make(chan struct{})}
+	r.addSubscriber(ctx, r.patterns, pattern, sub)
+	return sub.messages, nil
+}
+
+// Unsubscribe closes every subscription on the given channel or pattern
+func (r *MemoryRepository) Unsubscribe(ctx context.Context, channel string) error {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	for _, registry := range []map[string]map[*memorySubscriber]struct{}{r.subscribers, r.patterns} {
+		for sub := range registry[channel] {
+			sub.close()
+		}
+		delete(registry, channel)
+	}
+	return nil
+}
+
+// OnReconnect is a no-op: in-memory subscriptions never lose their connection
+func (r *MemoryRepository) OnReconnect(handler func(channel string)) {}
+
+// Subscribed reports whether channel or pattern has a live subscriber
+func (r *MemoryRepository) Subscribed(channel string) bool {
+	r.subMu.RLock()
+	defer r.subMu.RUnlock()
+
+	return len(r.subscribers[channel]) > 0 || len(r.patterns[channel]) > 0
+}
+
+// Ping always succeeds; the in-memory store has nothing to connect to
+func (r *MemoryRepository) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (r *MemoryRepository) addSubscriber(ctx context.Context, registry map[string]map[*memorySubscriber]struct{}, key string, sub *memorySubscriber) {
+	r.subMu.Lock()
+	if registry[key] == nil {
+		registry[key] = make(map[*memorySubscriber]struct{})
+	}
+	registry[key][sub] = struct{}{}
+	r.subMu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sub.stop:
+			return
+		case <-r.done:
+		}
+
+		r.subMu.Lock()
+		defer r.subMu.Unlock()
+		if subs, ok := registry[key]; ok {
+			delete(subs, sub)
+			if len(subs) == 0 {
+				delete(registry, key)
+			}
+		}
+		sub.close()
+	}()
+}
+
+// deliver must be called with subMu held. Slow subscribers drop messages
+// rather than block the publisher, matching Redis pub/sub's fire-and-forget
+// delivery.
+func (s *memorySubscriber) deliver(channel string, message []byte) {
+	payload := append([]byte(nil), message...)
+	if s.messages != nil {
+		select {
+		case s.messages <- Message{Channel: channel, Payload: payload}:
+		default:
+		}
+		return
+	}
+
+	select {
+	case s.payloads <- payload:
+	default:
+	}
+}
+
+// close must be called with subMu held
+func (s *memorySubscriber) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+		if s.messages != nil {
+			close(s.messages)
+		} else {
+			close(s.payloads)
+		}
+	}
+}
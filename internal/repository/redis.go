@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,14 +13,37 @@ import (
 
 type RedisRepository struct {
 	client *redis.Client
+
+	subMu             sync.Mutex
+	subscriptions     map[string]map[*redisSubscription]struct{}
+	reconnectHandlers []func(channel string)
+}
+
+// redisSubscription tracks one Subscribe/PSubscribe call so it can be torn down
+type redisSubscription struct {
+	channel string
+	pubsub  *redis.PubSub
+	cancel  context.CancelFunc
+	done    chan struct{}
 }
 
 func NewRedisRepository(client *redis.Client) *RedisRepository {
 	return &RedisRepository{
-		client: client,
+		client:        client,
+		subscriptions: make(map[string]map[*redisSubscription]struct{}),
 	}
 }
 
+// compareAndDeleteScript deletes a key only if it still holds the given value.
+// For presence keys this stops a stale disconnect on one pod from evicting a
+// newer connection the user made to another pod.
+var compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
 // User repository implementation
 func (r *RedisRepository) SaveUser(ctx context.Context, user *model.UserSession) error {
 	data, err := json.Marshal(user)
@@ -28,7 +52,17 @@ func (r *RedisRepository) SaveUser(ctx context.Context, user *model.UserSession)
 	}
 
 	key := fmt.Sprintf("user:%s", user.ID)
-	return r.client.Set(ctx, key, data, 24*time.Hour).Err()
+	if user.SessionID == "" {
+		return r.client.Set(ctx, key, data, 24*time.Hour).Err()
+	}
+
+	// Keep the session -> user index alive for as long as the user record
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, 24*time.Hour)
+		pipe.Set(ctx, fmt.Sprintf("session:%s", user.SessionID), user.ID, 24*time.Hour)
+		return nil
+	})
+	return err
 }
 
 func (r *RedisRepository) GetUser(ctx context.Context, userID string) (*model.UserSession, error) {
@@ -49,8 +83,36 @@ func (r *RedisRepository) GetUser(ctx context.Context, userID string) (*model.Us
 	return &user, nil
 }
 
+// GetUserBySession returns the user most recently bound to sessionID, or nil
+func (r *RedisRepository) GetUserBySession(ctx context.Context, sessionID string) (*model.UserSession, error) {
+	key := fmt.Sprintf("session:%s", sessionID)
+	userID, err := r.client.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	return r.GetUser(ctx, userID)
+}
+
 func (r *RedisRepository) DeleteUser(ctx context.Context, userID string) error {
+	user, err := r.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
 	key := fmt.Sprintf("user:%s", userID)
+	if user == nil || user.SessionID == "" {
+		return r.client.Del(ctx, key).Err()
+	}
+
+	// Only drop the session index if it still points at this user
+	sessionKey := fmt.Sprintf("session:%s", user.SessionID)
+	if err := compareAndDeleteScript.Run(ctx, r.client, []string{sessionKey}, userID).Err(); err != nil {
+		return fmt.Errorf("failed to delete session index: %w", err)
+	}
 	return r.client.Del(ctx, key).Err()
 }
 
@@ -69,19 +131,142 @@ func (r *RedisRepository) UpdateUserRoom(ctx context.Context, userID, roomID str
 }
 
 // Room repository implementation
-func (r *RedisRepository) SaveRoom(ctx context.Context, room *model.Room) error {
-	data, err := json.Marshal(room)
+//
+// A room is stored as two keys: room:<id> holds the JSON metadata and
+// room:<id>:users is a sorted set of member IDs scored by join time, so
+// membership changes are single atomic Redis operations instead of a
+// read-modify-write of the whole room. The rooms:index sorted set holds every
+// room ID scored by last activity in Unix milliseconds; it is updated in the
+// same operations and pruned of rooms whose keys have expired.
+
+const roomTTL = 24 * time.Hour
+
+const roomIndexKey = "rooms:index"
+
+// addUserToRoomScript adds a member only if the room is below capacity and
+// creates the room metadata on first join, unless ARGV[5] is empty. The
+// capacity stored in the room settings wins over ARGV[3], which only applies
+// to new rooms and rooms stored before settings existed. It returns 1 when
+// the user is a member, 0 when the room is full and -1 when it does not
+// exist and may not be created.
+//
+// KEYS[1] room metadata, KEYS[2] room members, KEYS[3] room index
+// ARGV[1] user ID, ARGV[2] join score, ARGV[3] capacity, ARGV[4] TTL seconds, ARGV[5] initial metadata, ARGV[6] room ID
+var addUserToRoomScript = redis.NewScript(`
+if redis.call("ZSCORE", KEYS[2], ARGV[1]) then
+	return 1
+end
+local capacity = tonumber(ARGV[3])
+local meta = redis.call("GET", KEYS[1])
+if not meta and ARGV[5] == "" then
+	return -1
+end
+if meta then
+	local settings = cjson.decode(meta)["settings"]
+	local max = type(settings) == "table" and tonumber(settings["max_participants"])
+	if max and max > 0 then
+		capacity = max
+	end
+end
+if redis.call("ZCARD", KEYS[2]) >= capacity then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+redis.call("SET", KEYS[1], ARGV[5], "NX")
+redis.call("EXPIRE", KEYS[1], ARGV[4])
+redis.call("EXPIRE", KEYS[2], ARGV[4])
+redis.call("ZADD", KEYS[3], ARGV[2], ARGV[6])
+return 1
+`)
+
+// createRoomScript stores the metadata of a room that does not exist yet.
+//
+// KEYS[1] room metadata, KEYS[2] room index
+// ARGV[1] metadata, ARGV[2] TTL seconds, ARGV[3] activity score, ARGV[4] room ID
+var createRoomScript = redis.NewScript(`
+if not redis.call("SET", KEYS[1], ARGV[1], "NX", "EX", ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[2], ARGV[3], ARGV[4])
+return 1
+`)
+
+// removeUserFromRoomScript removes a member and deletes the room once its
+// last member leaves. Removing a non-member leaves the room alone, so a room
+// created without members survives until someone has joined and left.
+//
+// KEYS[1] room metadata, KEYS[2] room members, KEYS[3] room index, KEYS[4] chat history, KEYS[5] lobby
+// ARGV[1] user ID, ARGV[2] activity score, ARGV[3] room ID, ARGV[4] TTL seconds
+var removeUserFromRoomScript = redis.NewScript(`
+local removed = redis.call("ZREM", KEYS[2], ARGV[1])
+if removed == 0 then
+	return 0
+end
+if redis.call("ZCARD", KEYS[2]) == 0 then
+	redis.call("DEL", KEYS[1], KEYS[2], KEYS[4], KEYS[5])
+	redis.call("ZREM", KEYS[3], ARGV[3])
+else
+	redis.call("EXPIRE", KEYS[1], ARGV[4])
+	redis.call("EXPIRE", KEYS[2], ARGV[4])
+	redis.call("ZADD", KEYS[3], "XX", ARGV[2], ARGV[3])
+end
+return removed
+`)
+
+func roomKey(roomID string) string {
+	return fmt.Sprintf("room:%s", roomID)
+}
+
+func roomUsersKey(roomID string) string {
+	return fmt.Sprintf("room:%s:users", roomID)
+}
+
+// roomLobbyKey is a sorted set of waiting user IDs scored by arrival time
+func roomLobbyKey(roomID string) string {
+	return fmt.Sprintf("room:%s:lobby", roomID)
+}
+
+// roomChatKey is a list of the room's recent chat messages as JSON, oldest first
+func roomChatKey(roomID string) string {
+	return fmt.Sprintf("room:%s:chat", roomID)
+}
+
+// roomBansKey is a sorted set of banned user IDs scored by ban expiry in ms
+func roomBansKey(roomID string) string {
+	return fmt.Sprintf("room:%s:bans", roomID)
+}
+
+// CreateRoom atomically stores a room that has no members yet
+func (r *RedisRepository) CreateRoom(ctx context.Context, room *model.Room) error {
+	meta := *room
+	meta.Users = nil
+	data, err := json.Marshal(&meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal room: %w", err)
 	}
 
-	key := fmt.Sprintf("room:%s", room.ID)
-	return r.client.Set(ctx, key, data, 24*time.Hour).Err()
+	keys := []string{roomKey(room.ID), roomIndexKey}
+	created, err := createRoomScript.Run(ctx, r.client, keys,
+		data, int(roomTTL.Seconds()), time.Now().UnixMilli(), room.ID).Int()
+	if err != nil {
+		return fmt.Errorf("failed to create room: %w", err)
+	}
+	if created == 0 {
+		return ErrRoomExists
+	}
+
+	return nil
 }
 
 func (r *RedisRepository) GetRoom(ctx context.Context, roomID string) (*model.Room, error) {
-	key := fmt.Sprintf("room:%s", roomID)
-	data, err := r.client.Get(ctx, key).Result()
+	pipe := r.client.Pipeline()
+	metaCmd := pipe.Get(ctx, roomKey(roomID))
+	usersCmd := pipe.ZRange(ctx, roomUsersKey(roomID), 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get room: %w", err)
+	}
+
+	data, err := metaCmd.Result()
 	if err != nil {
 		if err == redis.Nil {
 			return nil, nil
@@ -94,64 +279,435 @@ func (r *RedisRepository) GetRoom(ctx context.Context, roomID string) (*model.Ro
 		return nil, fmt.Errorf("failed to unmarshal room: %w", err)
 	}
 
+	room.Users = usersCmd.Val()
+	if room.Users == nil {
+		room.Users = []string{}
+	}
+
 	return &room, nil
 }
 
 func (r *RedisRepository) DeleteRoom(ctx context.Context, roomID string) error {
-	key := fmt.Sprintf("room:%s", roomID)
-	return r.client.Del(ctx, key).Err()
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, roomKey(roomID), roomUsersKey(roomID), roomChatKey(roomID), roomLobbyKey(roomID))
+		pipe.ZRem(ctx, roomIndexKey, roomID)
+		return nil
+	})
+	return err
 }
 
-func (r *RedisRepository) AddUserToRoom(ctx context.Context, roomID, userID string) error {
-	room, err := r.GetRoom(ctx, roomID)
+// AddUserToRoom atomically adds a user to the room, creating it from initial
+// if needed. It returns ErrRoomFull when the room is already at capacity.
+func (r *RedisRepository) AddUserToRoom(ctx context.Context, roomID, userID string, initial *model.Room) error {
+	var data []byte
+	capacity := model.DefaultMaxParticipants
+	if initial != nil {
+		meta := *initial
+		meta.Users = nil
+		var err error
+		if data, err = json.Marshal(&meta); err != nil {
+			return fmt.Errorf("failed to marshal room: %w", err)
+		}
+		capacity = initial.Settings.Capacity()
+	}
+
+	keys := []string{roomKey(roomID), roomUsersKey(roomID), roomIndexKey}
+	added, err := addUserToRoomScript.Run(ctx, r.client, keys,
+		userID, time.Now().UnixMilli(), capacity, int(roomTTL.Seconds()), data, roomID).Int()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to add user to room: %w", err)
+	}
+	switch added {
+	case 0:
+		return ErrRoomFull
+	case -1:
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+// RemoveUserFromRoom atomically removes a user and deletes the room once it is empty
+func (r *RedisRepository) RemoveUserFromRoom(ctx context.Context, roomID, userID string) error {
+	keys := []string{roomKey(roomID), roomUsersKey(roomID), roomIndexKey, roomChatKey(roomID), roomLobbyKey(roomID)}
+	if err := removeUserFromRoomScript.Run(ctx, r.client, keys, userID, time.Now().UnixMilli(), roomID, int(roomTTL.Seconds())).Err(); err != nil {
+		return fmt.Errorf("failed to remove user from room: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisRepository) GetRoomUsers(ctx context.Context, roomID string) ([]string, error) {
+	users, err := r.client.ZRange(ctx, roomUsersKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get room users: %w", err)
+	}
+
+	return users, nil
+}
+
+// ListRooms pages through the room index. Entries whose room key has
+// expired are removed from the index as they are found.
+func (r *RedisRepository) ListRooms(ctx context.Context, cursor string, limit int) ([]*model.Room, string, error) {
+	after, err := decodeRoomCursor(cursor)
+	if err != nil {
+		return nil, "", err
 	}
+	if err := r.pruneRoomIndex(ctx); err != nil {
+		return nil, "", err
+	}
+
+	var rooms []*model.Room
+	next := ""
+	for len(rooms) < limit {
+		entries, err := r.roomIndexPage(ctx, after, limit-len(rooms))
+		if err != nil {
+			return nil, "", err
+		}
+		if len(entries) == 0 {
+			next = ""
+			break
+		}
 
-	if room == nil {
-		room = &model.Room{
-			ID:        roomID,
-			Users:     []string{},
-			CreatedAt: time.Now(),
-			UpdatedAt: time.Now(),
+		for _, entry := range entries {
+			roomID := entry.Member.(string)
+			room, err := r.GetRoom(ctx, roomID)
+			if err != nil {
+				return nil, "", err
+			}
+			if room == nil {
+				r.client.ZRem(ctx, roomIndexKey, roomID)
+			} else {
+				rooms = append(rooms, room)
+			}
+			after = &roomCursor{activity: int64(entry.Score), roomID: roomID}
 		}
+		next = encodeRoomCursor(after.activity, after.roomID)
 	}
 
-	if !room.AddUser(userID) {
-		return fmt.Errorf("room is full or user already exists")
+	return rooms, next, nil
+}
+
+// roomIndexPage returns up to count index entries after the cursor, newest first
+func (r *RedisRepository) roomIndexPage(ctx context.Context, after *roomCursor, count int) ([]redis.Z, error) {
+	max := "+inf"
+	var entries []redis.Z
+	if after != nil {
+		// Rooms sharing the cursor's score and sorting below its ID come first
+		ties, err := r.client.ZRevRangeByScoreWithScores(ctx, roomIndexKey, &redis.ZRangeBy{
+			Min: fmt.Sprint(after.activity),
+			Max: fmt.Sprint(after.activity),
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list rooms: %w", err)
+		}
+		for _, entry := range ties {
+			if after.after(int64(entry.Score), entry.Member.(string)) && len(entries) < count {
+				entries = append(entries, entry)
+			}
+		}
+		max = fmt.Sprintf("(%d", after.activity)
+	}
+	if len(entries) == count {
+		return entries, nil
 	}
 
-	return r.SaveRoom(ctx, room)
+	rest, err := r.client.ZRevRangeByScoreWithScores(ctx, roomIndexKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   max,
+		Count: int64(count - len(entries)),
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rooms: %w", err)
+	}
+	return append(entries, rest...), nil
 }
 
-func (r *RedisRepository) RemoveUserFromRoom(ctx context.Context, roomID, userID string) error {
-	room, err := r.GetRoom(ctx, roomID)
+// CountRooms returns the size of the room index after dropping expired rooms
+func (r *RedisRepository) CountRooms(ctx context.Context) (int, error) {
+	if err := r.pruneRoomIndex(ctx); err != nil {
+		return 0, err
+	}
+
+	count, err := r.client.ZCard(ctx, roomIndexKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count rooms: %w", err)
+	}
+	return int(count), nil
+}
+
+// pruneRoomIndex drops index entries idle for longer than the room TTL. Room
+// keys expire roomTTL after the last join, leave or update, and each of those
+// bumps the score, so such rooms are certainly gone; more recent expiries are
+// caught by ListRooms.
+func (r *RedisRepository) pruneRoomIndex(ctx context.Context) error {
+	cutoff := time.Now().Add(-roomTTL).UnixMilli()
+	if err := r.client.ZRemRangeByScore(ctx, roomIndexKey, "-inf", fmt.Sprintf("(%d", cutoff)).Err(); err != nil {
+		return fmt.Errorf("failed to prune room index: %w", err)
+	}
+	return nil
+}
+
+// BanUser records a ban until now+duration. Bans are capped at the room
+// lifetime; the set expires roomTTL after the latest ban.
+func (r *RedisRepository) BanUser(ctx context.Context, roomID, userID string, duration time.Duration) error {
+	if duration <= 0 || duration > roomTTL {
+		duration = roomTTL
+	}
+
+	now := time.Now()
+	key := roomBansKey(roomID)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, key, "-inf", fmt.Sprint(now.UnixMilli()))
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(duration).UnixMilli()), Member: userID})
+		pipe.Expire(ctx, key, roomTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ban user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisRepository) IsBanned(ctx context.Context, roomID, userID string) (bool, error) {
+	until, err := r.client.ZScore(ctx, roomBansKey(roomID), userID).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
 	if err != nil {
+		return false, fmt.Errorf("failed to check ban: %w", err)
+	}
+
+	return int64(until) > time.Now().UnixMilli(), nil
+}
+
+func (r *RedisRepository) AddToLobby(ctx context.Context, roomID, userID string) error {
+	key := roomLobbyKey(roomID)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddNX(ctx, key, redis.Z{Score: float64(time.Now().UnixMilli()), Member: userID})
+		pipe.Expire(ctx, key, roomTTL)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add user to lobby: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RedisRepository) RemoveFromLobby(ctx context.Context, roomID, userID string) (bool, error) {
+	removed, err := r.client.ZRem(ctx, roomLobbyKey(roomID), userID).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove user from lobby: %w", err)
+	}
+
+	return removed > 0, nil
+}
+
+func (r *RedisRepository) GetLobby(ctx context.Context, roomID string) ([]string, error) {
+	users, err := r.client.ZRange(ctx, roomLobbyKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lobby: %w", err)
+	}
+
+	return users, nil
+}
+
+// appendChatMessageScript appends to a live room's chat history and trims it
+// to the newest messages, so no history outlives its room.
+//
+// KEYS[1] room metadata, KEYS[2] chat history
+// ARGV[1] message JSON, ARGV[2] history limit, ARGV[3] TTL seconds
+var appendChatMessageScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("RPUSH", KEYS[2], ARGV[1])
+redis.call("LTRIM", KEYS[2], -tonumber(ARGV[2]), -1)
+redis.call("EXPIRE", KEYS[2], ARGV[3])
+return 1
+`)
+
+func (r *RedisRepository) AppendChatMessage(ctx context.Context, roomID string, message *model.ChatMessageData, limit int) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chat message: %w", err)
+	}
+
+	keys := []string{roomKey(roomID), roomChatKey(roomID)}
+	appended, err := appendChatMessageScript.Run(ctx, r.client, keys, data, limit, int(roomTTL.Seconds())).Int()
+	if err != nil {
+		return fmt.Errorf("failed to append chat message: %w", err)
+	}
+	if appended == 0 {
+		return ErrRoomNotFound
+	}
+
+	return nil
+}
+
+func (r *RedisRepository) GetChatHistory(ctx context.Context, roomID string) ([]model.ChatMessageData, error) {
+	entries, err := r.client.LRange(ctx, roomChatKey(roomID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat history: %w", err)
+	}
+
+	history := make([]model.ChatMessageData, 0, len(entries))
+	for _, entry := range entries {
+		var message model.ChatMessageData
+		if err := json.Unmarshal([]byte(entry), &message); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal chat message: %w", err)
+		}
+		history = append(history, message)
+	}
+
+	return history, nil
+}
+
+// maxUpdateRetries bounds optimistic-locking retries in UpdateRoom
+const maxUpdateRetries = 10
+
+// UpdateRoom rewrites the room metadata under WATCH, retrying if another
+// writer changes it concurrently. Membership is read but never written; like
+// joins and leaves, an update counts as activity and refreshes the room's TTL.
+func (r *RedisRepository) UpdateRoom(ctx context.Context, roomID string, update func(room *model.Room) error) (*model.Room, error) {
+	key := roomKey(roomID)
+	var updated *model.Room
+
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return ErrRoomNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var room model.Room
+		if err := json.Unmarshal([]byte(data), &room); err != nil {
+			return fmt.Errorf("failed to unmarshal room: %w", err)
+		}
+		room.Users, err = tx.ZRange(ctx, roomUsersKey(roomID), 0, -1).Result()
+		if err != nil {
+			return err
+		}
+
+		if err := update(&room); err != nil {
+			return err
+		}
+		room.UpdatedAt = time.Now()
+
+		meta := room
+		meta.Users = nil
+		out, err := json.Marshal(&meta)
+		if err != nil {
+			return fmt.Errorf("failed to marshal room: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, out, roomTTL)
+			pipe.Expire(ctx, roomUsersKey(roomID), roomTTL)
+			pipe.ZAddXX(ctx, roomIndexKey, redis.Z{Score: float64(room.UpdatedAt.UnixMilli()), Member: roomID})
+			return nil
+		})
+		if err == nil {
+			updated = &room
+		}
 		return err
 	}
-	if room == nil {
-		return nil // Room doesn't exist, nothing to remove
+
+	for i := 0; i < maxUpdateRetries; i++ {
+		err := r.client.Watch(ctx, txf, key)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		return updated, err
 	}
 
-	room.RemoveUser(userID)
+	return nil, fmt.Errorf("failed to update room %s: too much contention", roomID)
+}
+
+// Presence repository implementation
+func (r *RedisRepository) SetUserNode(ctx context.Context, userID, nodeID string, ttl time.Duration) error {
+	key := fmt.Sprintf("presence:%s", userID)
+	return r.client.Set(ctx, key, nodeID, ttl).Err()
+}
 
-	if room.IsEmpty() {
-		return r.DeleteRoom(ctx, roomID)
+func (r *RedisRepository) GetUserNode(ctx context.Context, userID string) (string, error) {
+	key := fmt.Sprintf("presence:%s", userID)
+	nodeID, err := r.client.Get(ctx, key).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get user node: %w", err)
 	}
 
-	return r.SaveRoom(ctx, room)
+	return nodeID, nil
 }
 
-func (r *RedisRepository) GetRoomUsers(ctx context.Context, roomID string) ([]string, error) {
-	room, err := r.GetRoom(ctx, roomID)
+func (r *RedisRepository) RemoveUserNode(ctx context.Context, userID, nodeID string) error {
+	key := fmt.Sprintf("presence:%s", userID)
+	return compareAndDeleteScript.Run(ctx, r.client, []string{key}, nodeID).Err()
+}
+
+// Rate limit repository implementation
+//
+// Counters live in ratelimit:<key>:<window start> and expire with their
+// window. Slots are a sorted set ratelimit:<key> of holders scored by the
+// Unix millisecond their slot lapses.
+
+// acquireSlotScript drops lapsed slots, then renews or takes the holder's
+// slot if one is free
+var acquireSlotScript = redis.NewScript(`
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", ARGV[3])
+if redis.call("ZSCORE", KEYS[1], ARGV[1]) or redis.call("ZCARD", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("ZADD", KEYS[1], ARGV[4], ARGV[1])
+	redis.call("PEXPIRE", KEYS[1], ARGV[5])
+	return 1
+end
+return 0
+`)
+
+func rateLimitKey(key string) string {
+	return fmt.Sprintf("ratelimit:%s", key)
+}
+
+func (r *RedisRepository) IncrementCounter(ctx context.Context, key string, window time.Duration) (int64, error) {
+	start := time.Now().Truncate(window)
+	counterKey := fmt.Sprintf("%s:%d", rateLimitKey(key), start.Unix())
+
+	var incr *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, counterKey)
+		pipe.ExpireAt(ctx, counterKey, start.Add(window))
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("failed to increment counter: %w", err)
 	}
-	if room == nil {
-		return []string{}, nil
+
+	return incr.Val(), nil
+}
+
+func (r *RedisRepository) AcquireSlot(ctx context.Context, key, holder string, limit int, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	acquired, err := acquireSlotScript.Run(ctx, r.client, []string{rateLimitKey(key)},
+		holder, limit, now.UnixMilli(), now.Add(ttl).UnixMilli(), ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire slot: %w", err)
 	}
 
-	return room.Users, nil
+	return acquired == 1, nil
+}
+
+func (r *RedisRepository) ReleaseSlot(ctx context.Context, key, holder string) error {
+	if err := r.client.ZRem(ctx, rateLimitKey(key), holder).Err(); err != nil {
+		return fmt.Errorf("failed to release slot: %w", err)
+	}
+
+	return nil
 }
 
 // PubSub repository implementation
@@ -160,22 +716,166 @@ func (r *RedisRepository) Publish(ctx context.Context, channel string, message [
 }
 
 func (r *RedisRepository) Subscribe(ctx context.Context, channel string) (<-chan []byte, error) {
-	pubsub := r.client.Subscribe(ctx, channel)
-	ch := pubsub.Channel()
-
 	msgCh := make(chan []byte, 100)
-	go func() {
-		defer close(msgCh)
-		for msg := range ch {
-			msgCh <- []byte(msg.Payload)
+	err := r.subscribe(ctx, channel, r.client.Subscribe(ctx, channel), func(subCtx context.Context, msg *redis.Message) bool {
+		select {
+		case msgCh <- []byte(msg.Payload):
+			return true
+		case <-subCtx.Done():
+			return false
 		}
-	}()
+	}, func() { close(msgCh) })
+	if err != nil {
+		return nil, err
+	}
+
+	return msgCh, nil
+}
+
+// PSubscribe subscribes to every channel matching a Redis glob pattern
+func (r *RedisRepository) PSubscribe(ctx context.Context, pattern string) (<-chan Message, error) {
+	msgCh := make(chan Message, 100)
+	err := r.subscribe(ctx, pattern, r.client.PSubscribe(ctx, pattern), func(subCtx context.Context, msg *redis.Message) bool {
+		select {
+		case msgCh <- Message{Channel: msg.Channel, Payload: []byte(msg.Payload)}:
+			return true
+		case <-subCtx.Done():
+			return false
+		}
+	}, func() { close(msgCh) })
+	if err != nil {
+		return nil, err
+	}
 
 	return msgCh, nil
 }
 
+// Unsubscribe tears down every subscription on the given channel or pattern
+// and waits for their goroutines to exit
 func (r *RedisRepository) Unsubscribe(ctx context.Context, channel string) error {
-	// This is a simplified implementation
-	// In a real scenario, you'd need to manage subscriptions more carefully
+	r.subMu.Lock()
+	subs := r.subscriptions[channel]
+	delete(r.subscriptions, channel)
+	r.subMu.Unlock()
+
+	for sub := range subs {
+		sub.cancel()
+	}
+	for sub := range subs {
+		select {
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
 	return nil
 }
+
+// Subscribed reports whether a subscription on channel is registered. Its
+// goroutine removes it on exit, so a registered subscription is still being
+// pumped; go-redis reconnects it transparently after connection loss.
+func (r *RedisRepository) Subscribed(channel string) bool {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	return len(r.subscriptions[channel]) > 0
+}
+
+// Ping checks the connection to Redis
+func (r *RedisRepository) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisRepository) OnReconnect(handler func(channel string)) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	r.reconnectHandlers = append(r.reconnectHandlers, handler)
+}
+
+// subscribe waits for the subscription to be confirmed, registers it and
+// starts the goroutine that pumps messages to deliver until ctx is cancelled
+// or the subscription is removed
+func (r *RedisRepository) subscribe(
+	ctx context.Context,
+	channel string,
+	pubsub *redis.PubSub,
+	deliver func(context.Context, *redis.Message) bool,
+	closeOutput func(),
+) error {
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	subCtx, cancel := context.WithCancel(ctx)
+	sub := &redisSubscription{
+		channel: channel,
+		pubsub:  pubsub,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	r.subMu.Lock()
+	if r.subscriptions[channel] == nil {
+		r.subscriptions[channel] = make(map[*redisSubscription]struct{})
+	}
+	r.subscriptions[channel][sub] = struct{}{}
+	r.subMu.Unlock()
+
+	go func() {
+		defer close(sub.done)
+		defer closeOutput()
+		defer pubsub.Close()
+		defer r.removeSubscription(sub)
+
+		// The initial confirmation was consumed by Receive above, so every
+		// subscription event seen here means go-redis reconnected
+		events := pubsub.ChannelWithSubscriptions(redis.WithChannelSize(100))
+		for {
+			select {
+			case <-subCtx.Done():
+				return
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				switch event := event.(type) {
+				case *redis.Subscription:
+					if event.Kind == "subscribe" || event.Kind == "psubscribe" {
+						r.notifyReconnect(channel)
+					}
+				case *redis.Message:
+					if !deliver(subCtx, event) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+func (r *RedisRepository) removeSubscription(sub *redisSubscription) {
+	r.subMu.Lock()
+	defer r.subMu.Unlock()
+
+	if subs, ok := r.subscriptions[sub.channel]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(r.subscriptions, sub.channel)
+		}
+	}
+}
+
+func (r *RedisRepository) notifyReconnect(channel string) {
+	r.subMu.Lock()
+	handlers := append([]func(string){}, r.reconnectHandlers...)
+	r.subMu.Unlock()
+
+	for _, handler := range handlers {
+		handler(channel)
+	}
+}
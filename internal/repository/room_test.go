@@ -0,0 +1,220 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/signaling-server/internal/model"
+)
+
+// roomBackends returns a fresh Room repository of each kind; the Redis one
+// runs the Lua scripts against miniredis
+func roomBackends(t *testing.T) map[string]Room {
+	t.Helper()
+
+	memory := NewMemoryRepository()
+	t.Cleanup(func() { memory.Close() })
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]Room{
+		"memory": memory,
+		"redis":  NewRedisRepository(client),
+	}
+}
+
+func newTestRoom(roomID string, maxParticipants int) *model.Room {
+	return &model.Room{
+		ID:       roomID,
+		Settings: model.RoomSettings{MaxParticipants: maxParticipants},
+	}
+}
+
+func TestAddUserToRoomConcurrentCapacity(t *testing.T) {
+	const (
+		maxParticipants = 5
+		joiners         = 50
+	)
+
+	for name, repo := range roomBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			initial := newTestRoom("crowded", maxParticipants)
+
+			var (
+				wg     sync.WaitGroup
+				start  = make(chan struct{})
+				errs   = make([]error, joiners)
+				joined = make([]bool, joiners)
+			)
+			for i := 0; i < joiners; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					<-start
+					errs[i] = repo.AddUserToRoom(ctx, initial.ID, fmt.Sprintf("user-%d", i), initial)
+					joined[i] = errs[i] == nil
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			succeeded := 0
+			for i, err := range errs {
+				switch {
+				case err == nil:
+					succeeded++
+				case !errors.Is(err, ErrRoomFull):
+					t.Errorf("join %d: got %v, want nil or ErrRoomFull", i, err)
+				}
+			}
+			if succeeded != maxParticipants {
+				t.Errorf("%d joins succeeded, want %d", succeeded, maxParticipants)
+			}
+
+			users, err := repo.GetRoomUsers(ctx, initial.ID)
+			if err != nil {
+				t.Fatalf("GetRoomUsers: %v", err)
+			}
+			if len(users) != maxParticipants {
+				t.Fatalf("room has %d members, want %d", len(users), maxParticipants)
+			}
+			for _, userID := range users {
+				var i int
+				fmt.Sscanf(userID, "user-%d", &i)
+				if !joined[i] {
+					t.Errorf("member %s was told the room is full", userID)
+				}
+			}
+		})
+	}
+}
+
+func TestAddUserToRoomRejoinWhenFull(t *testing.T) {
+	for name, repo := range roomBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			initial := newTestRoom("pair", 2)
+
+			for _, userID := range []string{"alice", "bob"} {
+				if err := repo.AddUserToRoom(ctx, initial.ID, userID, initial); err != nil {
+					t.Fatalf("join %s: %v", userID, err)
+				}
+			}
+			if err := repo.AddUserToRoom(ctx, initial.ID, "carol", initial); !errors.Is(err, ErrRoomFull) {
+				t.Fatalf("third join: got %v, want ErrRoomFull", err)
+			}
+			// Members are let back in even though the room is full
+			if err := repo.AddUserToRoom(ctx, initial.ID, "alice", initial); err != nil {
+				t.Fatalf("rejoin: %v", err)
+			}
+		})
+	}
+}
+
+func TestAddUserToRoomWithoutInitial(t *testing.T) {
+	for name, repo := range roomBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			if err := repo.AddUserToRoom(ctx, "gone", "alice", nil); !errors.Is(err, ErrRoomNotFound) {
+				t.Fatalf("got %v, want ErrRoomNotFound", err)
+			}
+			room, err := repo.GetRoom(ctx, "gone")
+			if err != nil {
+				t.Fatalf("GetRoom: %v", err)
+			}
+			if room != nil {
+				t.Fatal("room was created")
+			}
+
+			if err := repo.AddUserToRoom(ctx, "here", "alice", newTestRoom("here", 2)); err != nil {
+				t.Fatalf("create: %v", err)
+			}
+			if err := repo.AddUserToRoom(ctx, "here", "bob", nil); err != nil {
+				t.Fatalf("join existing room: %v", err)
+			}
+		})
+	}
+}
+
+func TestRemoveUserFromRoom(t *testing.T) {
+	for name, repo := range roomBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			// A room created without members survives a stray removal
+			if err := repo.CreateRoom(ctx, newTestRoom("empty", 2)); err != nil {
+				t.Fatalf("CreateRoom: %v", err)
+			}
+			if err := repo.RemoveUserFromRoom(ctx, "empty", "stranger"); err != nil {
+				t.Fatalf("RemoveUserFromRoom: %v", err)
+			}
+			if room, err := repo.GetRoom(ctx, "empty"); err != nil || room == nil {
+				t.Fatalf("room deleted by removing a non-member: %v, %v", room, err)
+			}
+
+			// The last member leaving deletes the room and its lobby
+			initial := newTestRoom("busy", 2)
+			if err := repo.AddUserToRoom(ctx, initial.ID, "alice", initial); err != nil {
+				t.Fatalf("join: %v", err)
+			}
+			if err := repo.AddToLobby(ctx, initial.ID, "bob"); err != nil {
+				t.Fatalf("AddToLobby: %v", err)
+			}
+			if err := repo.RemoveUserFromRoom(ctx, initial.ID, "alice"); err != nil {
+				t.Fatalf("RemoveUserFromRoom: %v", err)
+			}
+			if room, err := repo.GetRoom(ctx, initial.ID); err != nil || room != nil {
+				t.Fatalf("room kept after the last member left: %v, %v", room, err)
+			}
+			if lobby, err := repo.GetLobby(ctx, initial.ID); err != nil || len(lobby) != 0 {
+				t.Fatalf("lobby kept after the last member left: %v, %v", lobby, err)
+			}
+		})
+	}
+}
+
+// Leaves and updates bump the room's index score, so they must keep the room
+// keys alive as long as a join would or CountRooms counts expired rooms
+func TestRedisRoomActivityRefreshesTTL(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	repo := NewRedisRepository(client)
+	ctx := context.Background()
+
+	initial := newTestRoom("lively", 3)
+	for _, userID := range []string{"alice", "bob"} {
+		if err := repo.AddUserToRoom(ctx, initial.ID, userID, initial); err != nil {
+			t.Fatalf("join %s: %v", userID, err)
+		}
+	}
+
+	server.FastForward(roomTTL - time.Hour)
+	if err := repo.RemoveUserFromRoom(ctx, initial.ID, "bob"); err != nil {
+		t.Fatalf("RemoveUserFromRoom: %v", err)
+	}
+	server.FastForward(roomTTL - time.Hour)
+	if _, err := repo.UpdateRoom(ctx, initial.ID, func(room *model.Room) error { return nil }); err != nil {
+		t.Fatalf("UpdateRoom: %v", err)
+	}
+	server.FastForward(roomTTL - time.Hour)
+
+	room, err := repo.GetRoom(ctx, initial.ID)
+	if err != nil {
+		t.Fatalf("GetRoom: %v", err)
+	}
+	if room == nil || len(room.Users) != 1 {
+		t.Fatalf("room expired despite activity: %+v", room)
+	}
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/signaling-server/internal/model"
+)
+
+// adminActor is the By value shown to users removed by an operator
+const adminActor = "admin"
+
+// RoomMember is a room member together with the node holding their connection
+type RoomMember struct {
+	UserID    string         `json:"user_id"`
+	Role      model.RoomRole `json:"role"`
+	Publisher bool           `json:"publisher"`
+	// Node is empty if the member is not connected to any node
+	Node string `json:"node,omitempty"`
+}
+
+// RoomDetails describes a room for operators
+type RoomDetails struct {
+	ID        string             `json:"id"`
+	Policy    RoomPolicyView     `json:"policy"`
+	Settings  model.RoomSettings `json:"settings"`
+	Members   []RoomMember       `json:"members"`
+	Lobby     []string           `json:"lobby,omitempty"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// RoomPolicyView is a room policy without its password hash
+type RoomPolicyView struct {
+	Owner             string              `json:"owner"`
+	Locked            bool                `json:"locked"`
+	PasswordProtected bool                `json:"password_protected"`
+	LobbyEnabled      bool                `json:"lobby_enabled"`
+	AllowedUsers      []string            `json:"allowed_users,omitempty"`
+	AllowedClaims     map[string][]string `json:"allowed_claims,omitempty"`
+}
+
+// ConnectionInfo describes a connection held by this node
+type ConnectionInfo struct {
+	UserID      string    `json:"user_id"`
+	SessionID   string    `json:"session_id"`
+	Subject     string    `json:"subject,omitempty"`
+	DisplayName string    `json:"display_name,omitempty"`
+	RemoteIP    string    `json:"remote_ip"`
+	RoomID      string    `json:"room_id,omitempty"`
+	LobbyRoomID string    `json:"lobby_room_id,omitempty"`
+	ConnectedAt time.Time `json:"connected_at"`
+	QueueDepth  int       `json:"queue_depth"`
+}
+
+// ListRoomDetails returns a page of rooms, most recently active first, with
+// their members and the nodes they are connected to, and the next page's cursor
+func (s *SignalingService) ListRoomDetails(ctx context.Context, cursor string, limit int) ([]RoomDetails, string, error) {
+	rooms, next, err := s.roomService.ListRooms(ctx, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+
+	details := make([]RoomDetails, 0, len(rooms))
+	for _, room := range rooms {
+		details = append(details, s.roomDetails(ctx, room))
+	}
+	return details, next, nil
+}
+
+// CountRooms returns the number of live rooms across all nodes
+func (s *SignalingService) CountRooms(ctx context.Context) (int, error) {
+	return s.roomService.CountRooms(ctx)
+}
+
+// GetRoomDetails returns the room with its members, their nodes and the
+// lobby, or nil if there is no such room
+func (s *SignalingService) GetRoomDetails(ctx context.Context, roomID string) (*RoomDetails, error) {
+	room, err := s.roomService.GetRoom(ctx, roomID)
+	if err != nil || room == nil {
+		return nil, err
+	}
+
+	details := s.roomDetails(ctx, room)
+	if details.Lobby, err = s.roomService.GetLobby(ctx, roomID); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+func (s *SignalingService) roomDetails(ctx context.Context, room *model.Room) RoomDetails {
+	details := RoomDetails{
+		ID: room.ID,
+		Policy: RoomPolicyView{
+			Owner:             room.Policy.Owner,
+			Locked:            room.Policy.Locked,
+			PasswordProtected: room.Policy.HasPassword(),
+			LobbyEnabled:      room.Policy.LobbyEnabled,
+			AllowedUsers:      room.Policy.AllowedUsers,
+			AllowedClaims:     room.Policy.AllowedClaims,
+		},
+		Settings:  room.Settings,
+		Members:   make([]RoomMember, 0, len(room.Users)),
+		CreatedAt: room.CreatedAt,
+		UpdatedAt: room.UpdatedAt,
+	}
+	for _, userID := range room.Users {
+		details.Members = append(details.Members, RoomMember{
+			UserID:    userID,
+			Role:      room.RoleOf(userID),
+			Publisher: room.IsPublisher(userID),
+			Node:      s.userNode(ctx, userID),
+		})
+	}
+	return details
+}
+
+// userNode returns the node holding the user's connection, or "" if none does
+func (s *SignalingService) userNode(ctx context.Context, userID string) string {
+	if _, exists := s.GetConnection(userID); exists {
+		return s.config.NodeID
+	}
+	nodeID, err := s.presence.GetUserNode(ctx, userID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to look up node for user %s: %v", userID, err)
+	}
+	return nodeID
+}
+
+// CreateRoom creates an empty room with the given settings on behalf of an
+// operator. The first user to join becomes its owner and host. It returns
+// ErrInvalidRoomSettings or repository.ErrRoomExists like RoomService.CreateRoom.
+func (s *SignalingService) CreateRoom(ctx context.Context, roomID, password string, settings model.RoomSettingsData) (*RoomDetails, error) {
+	room, err := s.roomService.CreateRoom(ctx, roomID, "", password, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log(ctx).Infof("Operator created room %s", roomID)
+
+	details := s.roomDetails(ctx, room)
+	return &details, nil
+}
+
+// CloseRoom ends a room on behalf of an operator and tells its members.
+// It returns repository.ErrRoomNotFound if there is no such room.
+func (s *SignalingService) CloseRoom(ctx context.Context, roomID string) error {
+	members, err := s.roomService.CloseRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	s.log(ctx).Infof("Operator closed room %s (%d members)", roomID, len(members))
+
+	endedMsg := &model.Message{
+		Type:      model.MessageTypeRoomEnded,
+		RoomID:    roomID,
+		Timestamp: time.Now().Unix(),
+	}
+	endedMsg.Data, _ = json.Marshal(model.RemovedFromRoomData{RoomID: roomID, Reason: "room_ended", By: adminActor})
+	s.broadcastToUsers(ctx, members, endedMsg)
+	return nil
+}
+
+// KickUser removes a member from a room on behalf of an operator. Like a
+// host's kick, it keeps them out for a few minutes. It returns
+// ErrUserNotInRoom if the user is not a member.
+func (s *SignalingService) KickUser(ctx context.Context, roomID, userID string) error {
+	members, err := s.roomService.GetRoomUsers(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if !containsUser(members, userID) {
+		return ErrUserNotInRoom
+	}
+
+	if err := s.roomService.BanUser(ctx, roomID, userID, kickBanDuration); err != nil {
+		return fmt.Errorf("failed to ban user: %w", err)
+	}
+
+	s.log(ctx).Infof("Operator kicked user %s from room %s", userID, roomID)
+
+	removedMsg := &model.Message{
+		Type:      model.MessageTypeRemovedFromRoom,
+		RoomID:    roomID,
+		Timestamp: time.Now().Unix(),
+	}
+	removedMsg.Data, _ = json.Marshal(model.RemovedFromRoomData{RoomID: roomID, Reason: "kicked", By: adminActor})
+	if err := s.forwardToUser(ctx, userID, removedMsg); err != nil && !errors.Is(err, ErrUserNotConnected) {
+		s.log(ctx).Errorf("Failed to notify removed user %s: %v", userID, err)
+	}
+
+	return s.leaveRoom(ctx, userID, roomID)
+}
+
+// SendNotice sends a system_notice to every connected member of the room and
+// returns how many members it was sent to
+func (s *SignalingService) SendNotice(ctx context.Context, roomID, message string) (int, error) {
+	members, err := s.roomService.GetRoomUsers(ctx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	noticeMsg := &model.Message{
+		Type:      model.MessageTypeSystemNotice,
+		RoomID:    roomID,
+		Timestamp: time.Now().Unix(),
+	}
+	noticeMsg.Data, _ = json.Marshal(model.SystemNoticeData{Message: message})
+
+	connected := s.filterConnectedUsers(ctx, members)
+	s.broadcastToUsers(ctx, connected, noticeMsg)
+	return len(connected), nil
+}
+
+// Connections lists the connections held by this node
+func (s *SignalingService) Connections() []ConnectionInfo {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	connections := make([]ConnectionInfo, 0, len(s.connections))
+	for _, user := range s.connections {
+		info := ConnectionInfo{
+			UserID:      user.ID,
+			SessionID:   user.SessionID,
+			DisplayName: user.DisplayName,
+			RemoteIP:    user.RemoteIP,
+			RoomID:      user.RoomID,
+			LobbyRoomID: user.LobbyRoomID,
+			ConnectedAt: user.CreatedAt,
+			QueueDepth:  user.Connection.QueueDepth(),
+		}
+		if user.Identity != nil {
+			info.Subject = user.Identity.Subject
+		}
+		connections = append(connections, info)
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].UserID < connections[j].UserID })
+	return connections
+}
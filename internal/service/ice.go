@@ -0,0 +1,84 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/signaling-server/internal/model"
+)
+
+// ICEConfig configures the ICE servers handed to clients
+type ICEConfig struct {
+	STUNURLs  []string
+	TURNURLs  []string
+	TURNSURLs []string
+	// TURNSecret is the coturn static-auth-secret; TURN servers are omitted without it
+	TURNSecret    string
+	CredentialTTL time.Duration
+}
+
+// ICEService issues ICE server lists with short-lived TURN credentials using
+// the coturn TURN REST API scheme (use-auth-secret): the username is
+// "<expiry>:<userID>" and the password is base64(HMAC-SHA1(secret, username)).
+type ICEService struct {
+	mu     sync.RWMutex
+	config ICEConfig
+}
+
+func NewICEService(config ICEConfig) *ICEService {
+	return &ICEService{config: config}
+}
+
+// SetURLs replaces the advertised STUN, TURN and TURNS URLs. The TURN secret
+// and credential lifetime stay as configured at startup.
+func (s *ICEService) SetURLs(stunURLs, turnURLs, turnsURLs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.STUNURLs, s.config.TURNURLs, s.config.TURNSURLs = stunURLs, turnURLs, turnsURLs
+}
+
+// ServersFor returns the ICE servers for a user with freshly signed TURN credentials
+func (s *ICEService) ServersFor(userID string) model.STUNConfigData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var data model.STUNConfigData
+	if len(s.config.STUNURLs) > 0 {
+		data.ICEServers = append(data.ICEServers, model.ICEServer{URLs: s.config.STUNURLs})
+	}
+
+	turnURLs := append(append([]string{}, s.config.TURNURLs...), s.config.TURNSURLs...)
+	if s.config.TURNSecret == "" || len(turnURLs) == 0 {
+		return data
+	}
+
+	expiresAt := time.Now().Add(s.config.CredentialTTL).Unix()
+	username := fmt.Sprintf("%d:%s", expiresAt, userID)
+	data.ICEServers = append(data.ICEServers, model.ICEServer{
+		URLs:       turnURLs,
+		Username:   username,
+		Credential: s.credential(username),
+	})
+	data.ExpiresAt = expiresAt
+	return data
+}
+
+// RefreshInterval is how often credentials should be reissued on a live
+// connection, leaving a quarter of their lifetime as margin. It is zero when
+// no TURN credentials are issued.
+func (s *ICEService) RefreshInterval() time.Duration {
+	if s.config.TURNSecret == "" || s.config.CredentialTTL <= 0 {
+		return 0
+	}
+	return s.config.CredentialTTL * 3 / 4
+}
+
+func (s *ICEService) credential(username string) string {
+	mac := hmac.New(sha1.New, []byte(s.config.TURNSecret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/signaling-server/internal/model"
+	"github.com/signaling-server/internal/ratelimit"
+	"github.com/signaling-server/internal/repository"
+)
+
+// ErrTooManyConnections is returned when a client IP or session already
+// holds as many connections as it may
+var ErrTooManyConnections = errors.New("too many connections")
+
+// ErrRateLimitAbuse is returned by HandleMessage once a connection has kept
+// exceeding its rate limits; the connection should be closed
+var ErrRateLimitAbuse = errors.New("rate limits repeatedly exceeded")
+
+// RateLimitConfig bounds what a single client may do. Zero disables a limit.
+type RateLimitConfig struct {
+	// Messages limits each connection per message type; ICE candidates,
+	// which come in bursts, have their own limit
+	Messages      ratelimit.Limit
+	ICECandidates ratelimit.Limit
+	// MaxViolations refused messages or joins within a minute close the connection
+	MaxViolations int
+
+	// The connection and join limits are shared by every node
+	MaxConnectionsPerIP      int
+	MaxConnectionsPerSession int
+	JoinsPerMinute           int
+	// SlotTTL is how long a connection counts against its IP and session
+	// without being renewed, so the slots of a crashed node free up
+	SlotTTL time.Duration
+}
+
+// RateLimitService enforces the per-connection message limits and the
+// connection and join limits held in the repository
+type RateLimitService struct {
+	repo repository.RateLimit
+
+	mu     sync.RWMutex
+	config RateLimitConfig
+}
+
+func NewRateLimitService(repo repository.RateLimit, config RateLimitConfig) *RateLimitService {
+	if config.SlotTTL <= 0 {
+		config.SlotTTL = 90 * time.Second
+	}
+	return &RateLimitService{repo: repo, config: config}
+}
+
+// SetConfig replaces the limits. SlotTTL stays as configured at startup.
+// Existing connections keep their message limits until LimiterConfig is
+// applied to their limiters.
+func (s *RateLimitService) SetConfig(config RateLimitConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	config.SlotTTL = s.config.SlotTTL
+	s.config = config
+}
+
+func (s *RateLimitService) limits() RateLimitConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config
+}
+
+// NewConnectionLimiter creates the message limiter of a new connection
+func (s *RateLimitService) NewConnectionLimiter() *ratelimit.Limiter {
+	return ratelimit.New(s.LimiterConfig())
+}
+
+// LimiterConfig is the configuration of connection message limiters
+func (s *RateLimitService) LimiterConfig() ratelimit.Config {
+	config := s.limits()
+	return ratelimit.Config{
+		Default:         config.Messages,
+		Keys:            map[string]ratelimit.Limit{string(model.MessageTypeIceCandidate): config.ICECandidates},
+		MaxViolations:   config.MaxViolations,
+		ViolationWindow: time.Minute,
+	}
+}
+
+// AcquireConnection counts connection connID against its client IP and
+// session. It returns ErrTooManyConnections if either is at its limit.
+func (s *RateLimitService) AcquireConnection(ctx context.Context, ip, sessionID, connID string) error {
+	config := s.limits()
+	if err := s.acquireSlot(ctx, ipSlotKey(ip), connID, config.MaxConnectionsPerIP); err != nil {
+		return fmt.Errorf("ip %s: %w", ip, err)
+	}
+	if err := s.acquireSlot(ctx, sessionSlotKey(sessionID), connID, config.MaxConnectionsPerSession); err != nil {
+		s.releaseSlot(ctx, ipSlotKey(ip), connID, config.MaxConnectionsPerIP)
+		return fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	return nil
+}
+
+// RenewConnection keeps a live connection's slots from lapsing; call it
+// every RenewInterval
+func (s *RateLimitService) RenewConnection(ctx context.Context, ip, sessionID, connID string) error {
+	return s.AcquireConnection(ctx, ip, sessionID, connID)
+}
+
+// RenewInterval is how often live connections must be renewed
+func (s *RateLimitService) RenewInterval() time.Duration {
+	return s.limits().SlotTTL / 3
+}
+
+// ReleaseConnection frees the slots of a closed connection
+func (s *RateLimitService) ReleaseConnection(ctx context.Context, ip, sessionID, connID string) error {
+	config := s.limits()
+	return errors.Join(
+		s.releaseSlot(ctx, ipSlotKey(ip), connID, config.MaxConnectionsPerIP),
+		s.releaseSlot(ctx, sessionSlotKey(sessionID), connID, config.MaxConnectionsPerSession),
+	)
+}
+
+// AllowJoin counts a room join from ip and reports whether it is within the
+// per-minute limit
+func (s *RateLimitService) AllowJoin(ctx context.Context, ip string) (bool, error) {
+	joinsPerMinute := s.limits().JoinsPerMinute
+	if joinsPerMinute <= 0 {
+		return true, nil
+	}
+	count, err := s.repo.IncrementCounter(ctx, "joins:"+ip, time.Minute)
+	if err != nil {
+		return true, err
+	}
+	return count <= int64(joinsPerMinute), nil
+}
+
+func (s *RateLimitService) acquireSlot(ctx context.Context, key, connID string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	acquired, err := s.repo.AcquireSlot(ctx, key, connID, limit, s.limits().SlotTTL)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		return ErrTooManyConnections
+	}
+	return nil
+}
+
+func (s *RateLimitService) releaseSlot(ctx context.Context, key, connID string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	return s.repo.ReleaseSlot(ctx, key, connID)
+}
+
+func ipSlotKey(ip string) string {
+	return "conns:ip:" + ip
+}
+
+func sessionSlotKey(sessionID string) string {
+	return "conns:session:" + sessionID
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/signaling-server/internal/model"
 	"github.com/signaling-server/internal/repository"
@@ -11,62 +13,565 @@ import (
 type RoomService struct {
 	roomRepo repository.Room
 	userRepo repository.User
+	config   RoomConfig
 }
 
-func NewRoomService(roomRepo repository.Room, userRepo repository.User) *RoomService {
+// RoomConfig holds the settings given to rooms that don't override them
+type RoomConfig struct {
+	Defaults     model.RoomSettings
+	LobbyEnabled bool
+	// MaxParticipantsLimit caps the max_participants a room may ask for
+	MaxParticipantsLimit int
+	// ChatHistorySize is how many recent chat messages each room keeps for
+	// late joiners; 0 keeps none
+	ChatHistorySize int
+}
+
+func NewRoomService(roomRepo repository.Room, userRepo repository.User, config RoomConfig) *RoomService {
 	return &RoomService{
 		roomRepo: roomRepo,
 		userRepo: userRepo,
+		config:   config,
 	}
 }
 
-// JoinRoom adds a user to a room
-func (s *RoomService) JoinRoom(ctx context.Context, userID, roomID string) (*model.Room, error) {
-	// Check if room exists and has space
-	room, err := s.roomRepo.GetRoom(ctx, roomID)
+var (
+	// ErrNotRoomOwner is returned when someone other than the owner changes a room policy
+	ErrNotRoomOwner = errors.New("only the room owner can change the room policy")
+	// ErrOwnerNotMember is returned when ownership is handed to a user outside the room
+	ErrOwnerNotMember = errors.New("new owner must be a member of the room")
+	// ErrNotPermitted is returned when a member lacks the role for a moderation action
+	ErrNotPermitted = errors.New("not permitted")
+	// ErrUserNotInRoom is returned when a moderation action targets a non-member
+	ErrUserNotInRoom = errors.New("user is not in the room")
+	// ErrLobbyWaiting is returned by JoinRoom when the user was placed in the lobby
+	ErrLobbyWaiting = errors.New("waiting in lobby")
+	// ErrNotInLobby is returned when admitting or denying a user who is not waiting
+	ErrNotInLobby = errors.New("user is not waiting in the lobby")
+	// ErrInvalidRoomSettings is returned when a new room's settings are out of range
+	ErrInvalidRoomSettings = errors.New("invalid room settings")
+
+	errPublishersFull = errors.New("publisher slots are taken")
+)
+
+// JoinDeniedError is returned when a room policy refuses a join
+type JoinDeniedError struct {
+	Reason model.JoinDeniedReason
+}
+
+func (e *JoinDeniedError) Error() string {
+	return fmt.Sprintf("join denied: %s", e.Reason)
+}
+
+// JoinRequest describes a user's attempt to join a room. E2EE and
+// ReceiveOnly are what the client declared in its join_room message. Create,
+// if set, creates the room with these settings and fails with
+// repository.ErrRoomExists if it already exists.
+type JoinRequest struct {
+	UserID      string
+	RoomID      string
+	Identity    *model.Identity
+	Password    string
+	E2EE        bool
+	ReceiveOnly bool
+	Create      *model.RoomSettingsData
+}
+
+// JoinRoom adds a user to a room after checking the room policy. The capacity
+// check is done atomically by the repository, so it returns
+// repository.ErrRoomFull when the room is at capacity and *JoinDeniedError
+// when the policy refuses the user or every publisher slot is taken. If the
+// room has a lobby the user is queued there and ErrLobbyWaiting is returned.
+// The first user to join becomes the owner and host; a host or moderator role
+// claim in the user's token is applied on join.
+func (s *RoomService) JoinRoom(ctx context.Context, req JoinRequest) (*model.Room, error) {
+	// Authenticated users may be restricted to the rooms named in their token
+	if req.Identity != nil && !req.Identity.CanAccessRoom(req.RoomID) {
+		return nil, &JoinDeniedError{Reason: model.JoinDeniedNotAllowed}
+	}
+
+	banned, err := s.roomRepo.IsBanned(ctx, req.RoomID, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, &JoinDeniedError{Reason: model.JoinDeniedBanned}
+	}
+
+	var room *model.Room
+	if req.Create != nil {
+		room, err = s.CreateRoom(ctx, req.RoomID, req.UserID, req.Password, *req.Create)
+	} else {
+		room, err = s.roomRepo.GetRoom(ctx, req.RoomID)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// If room doesn't exist, it will be created in AddUserToRoom
-	if room != nil && !room.CanJoin() {
-		return nil, fmt.Errorf("room is full")
+	wasMember := false
+	if room != nil {
+		wasMember = containsUser(room.Users, req.UserID)
+		if reason, ok := checkPolicy(room, req); !ok {
+			return nil, &JoinDeniedError{Reason: reason}
+		}
+
+		if room.Policy.LobbyEnabled && !bypassesLobby(room, req) {
+			if err := s.roomRepo.AddToLobby(ctx, req.RoomID, req.UserID); err != nil {
+				return nil, err
+			}
+			return nil, ErrLobbyWaiting
+		}
+	}
+
+	// Add user to room, creating it with the defaults if it doesn't exist yet
+	if err := s.roomRepo.AddUserToRoom(ctx, req.RoomID, req.UserID, s.newRoom(req.RoomID, req.UserID)); err != nil {
+		return nil, err
 	}
 
-	// Add user to room
-	if err := s.roomRepo.AddUserToRoom(ctx, roomID, userID); err != nil {
+	var claimed model.RoomRole
+	if req.Identity != nil {
+		claimed = model.RoomRole(req.Identity.Role)
+	}
+	room, err = s.roomRepo.UpdateRoom(ctx, req.RoomID, func(room *model.Room) error {
+		// Rooms created by an operator belong to whoever joins first
+		if room.Policy.Owner == "" {
+			room.Policy.Owner = req.UserID
+			room.SetRole(req.UserID, model.RoomRoleHost)
+		}
+		if req.ReceiveOnly {
+			room.RemovePublisher(req.UserID)
+		} else if !room.AddPublisher(req.UserID) {
+			return errPublishersFull
+		}
+		if claimed.CanModerate() && claimed.Outranks(room.RoleOf(req.UserID)) {
+			room.SetRole(req.UserID, claimed)
+		}
+		return nil
+	})
+	if errors.Is(err, errPublishersFull) {
+		if !wasMember {
+			s.roomRepo.RemoveUserFromRoom(ctx, req.RoomID, req.UserID)
+		}
+		return nil, &JoinDeniedError{Reason: model.JoinDeniedPublishersFull}
+	}
+	if err != nil {
 		return nil, err
 	}
 
 	// Update user's room
+	if err := s.userRepo.UpdateUserRoom(ctx, req.UserID, req.RoomID); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// CreateRoom stores a new room with the server defaults overridden by
+// settings. ownerID becomes the owner and host; rooms created by operators
+// have none until someone joins. It returns ErrInvalidRoomSettings if the
+// settings are out of range and repository.ErrRoomExists if the ID is taken.
+func (s *RoomService) CreateRoom(ctx context.Context, roomID, ownerID, password string, settings model.RoomSettingsData) (*model.Room, error) {
+	room := s.newRoom(roomID, ownerID)
+	if err := s.applySettings(room, settings); err != nil {
+		return nil, err
+	}
+	if err := room.Policy.SetPassword(password); err != nil {
+		return nil, err
+	}
+
+	if err := s.roomRepo.CreateRoom(ctx, room); err != nil {
+		return nil, err
+	}
+	room.Users = []string{}
+	return room, nil
+}
+
+// newRoom returns a room with the server default settings
+func (s *RoomService) newRoom(roomID, ownerID string) *model.Room {
+	now := time.Now()
+	room := &model.Room{
+		ID:        roomID,
+		Policy:    model.RoomPolicy{Owner: ownerID, LobbyEnabled: s.config.LobbyEnabled},
+		Settings:  s.config.Defaults,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	room.Settings.MaxParticipants = room.Settings.Capacity()
+	if ownerID != "" {
+		room.SetRole(ownerID, model.RoomRoleHost)
+	}
+	return room
+}
+
+// applySettings checks a new room's overrides and applies them
+func (s *RoomService) applySettings(room *model.Room, update model.RoomSettingsData) error {
+	settings := &room.Settings
+	if update.MaxParticipants != nil {
+		settings.MaxParticipants = *update.MaxParticipants
+	}
+	if update.MaxPublishers != nil {
+		settings.MaxPublishers = *update.MaxPublishers
+	}
+	if update.AllowScreenShare != nil {
+		settings.AllowScreenShare = *update.AllowScreenShare
+	}
+	if update.E2EERequired != nil {
+		settings.E2EERequired = *update.E2EERequired
+	}
+	if update.LobbyEnabled != nil {
+		room.Policy.LobbyEnabled = *update.LobbyEnabled
+	}
+
+	limit := s.config.MaxParticipantsLimit
+	if settings.MaxParticipants < 1 || (limit > 0 && settings.MaxParticipants > limit) {
+		return fmt.Errorf("%w: max_participants must be between 1 and %d", ErrInvalidRoomSettings, limit)
+	}
+	if settings.MaxPublishers < 0 || settings.MaxPublishers > settings.MaxParticipants {
+		return fmt.Errorf("%w: max_publishers must be between 0 and max_participants", ErrInvalidRoomSettings)
+	}
+	return nil
+}
+
+// checkPolicy decides whether req may join room. The owner and existing
+// members are always let back in.
+func checkPolicy(room *model.Room, req JoinRequest) (model.JoinDeniedReason, bool) {
+	if room.Policy.Owner == req.UserID || containsUser(room.Users, req.UserID) {
+		return "", true
+	}
+
+	policy := &room.Policy
+	if policy.Locked {
+		return model.JoinDeniedLocked, false
+	}
+	if room.Settings.E2EERequired && !req.E2EE {
+		return model.JoinDeniedE2EERequired, false
+	}
+	if !policy.IsAllowed(req.UserID, req.Identity) {
+		return model.JoinDeniedNotAllowed, false
+	}
+	if policy.HasPassword() {
+		if req.Password == "" {
+			return model.JoinDeniedPasswordRequired, false
+		}
+		if !policy.CheckPassword(req.Password) {
+			return model.JoinDeniedInvalidPassword, false
+		}
+	}
+
+	return "", true
+}
+
+// bypassesLobby checks if the user may skip the lobby: the owner, existing
+// members, users whose token grants a host or moderator role and the first
+// to join a room without an owner
+func bypassesLobby(room *model.Room, req JoinRequest) bool {
+	if room.Policy.Owner == "" || room.Policy.Owner == req.UserID || containsUser(room.Users, req.UserID) {
+		return true
+	}
+	return req.Identity != nil && model.RoomRole(req.Identity.Role).CanModerate()
+}
+
+// AdmitFromLobby moves a waiting user into the room on behalf of a host or
+// moderator and returns the updated room. Banned users are refused with
+// ErrNotPermitted. If the room is full the user stays in the lobby and
+// repository.ErrRoomFull is returned. If the room has ended meanwhile
+// repository.ErrRoomNotFound is returned, and a JoinDeniedError if the room
+// was locked, the user is no longer allowed in or every publisher slot is
+// taken.
+func (s *RoomService) AdmitFromLobby(ctx context.Context, actorID, roomID, userID string) (*model.Room, error) {
+	room, err := s.requireModerator(ctx, actorID, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	banned, err := s.roomRepo.IsBanned(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, ErrNotPermitted
+	}
+
+	waiting, err := s.roomRepo.RemoveFromLobby(ctx, roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !waiting {
+		return nil, ErrNotInLobby
+	}
+
+	// The policy may have changed since the user queued. Claims were checked
+	// then and can't be now, as the user's token is on their own connection.
+	if room.Policy.Locked {
+		return nil, &JoinDeniedError{Reason: model.JoinDeniedLocked}
+	}
+	if len(room.Policy.AllowedClaims) == 0 && !room.Policy.IsAllowed(userID, nil) {
+		return nil, &JoinDeniedError{Reason: model.JoinDeniedNotAllowed}
+	}
+
+	// A nil initial room keeps an ended room from being recreated
+	if err := s.roomRepo.AddUserToRoom(ctx, roomID, userID, nil); err != nil {
+		if errors.Is(err, repository.ErrRoomFull) {
+			if lobbyErr := s.roomRepo.AddToLobby(ctx, roomID, userID); lobbyErr != nil {
+				return nil, fmt.Errorf("failed to return user to the lobby of full room: %w", lobbyErr)
+			}
+		}
+		return nil, err
+	}
+
+	room, err = s.roomRepo.UpdateRoom(ctx, roomID, func(room *model.Room) error {
+		if !room.AddPublisher(userID) {
+			return errPublishersFull
+		}
+		return nil
+	})
+	if errors.Is(err, errPublishersFull) {
+		s.roomRepo.RemoveUserFromRoom(ctx, roomID, userID)
+		return nil, &JoinDeniedError{Reason: model.JoinDeniedPublishersFull}
+	}
+	if err != nil {
+		return nil, err
+	}
 	if err := s.userRepo.UpdateUserRoom(ctx, userID, roomID); err != nil {
 		return nil, err
 	}
+	return room, nil
+}
 
-	// Return updated room
-	return s.roomRepo.GetRoom(ctx, roomID)
+// DenyFromLobby turns a waiting user away on behalf of a host or moderator
+func (s *RoomService) DenyFromLobby(ctx context.Context, actorID, roomID, userID string) error {
+	if _, err := s.requireModerator(ctx, actorID, roomID); err != nil {
+		return err
+	}
+
+	waiting, err := s.roomRepo.RemoveFromLobby(ctx, roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !waiting {
+		return ErrNotInLobby
+	}
+	return nil
 }
 
-// LeaveRoom removes a user from a room
-func (s *RoomService) LeaveRoom(ctx context.Context, userID, roomID string) error {
+// LeaveLobby removes a user who stopped waiting and reports whether they were in the lobby
+func (s *RoomService) LeaveLobby(ctx context.Context, roomID, userID string) (bool, error) {
+	return s.roomRepo.RemoveFromLobby(ctx, roomID, userID)
+}
+
+// GetLobby returns the users waiting to join the room, longest-waiting first
+func (s *RoomService) GetLobby(ctx context.Context, roomID string) ([]string, error) {
+	return s.roomRepo.GetLobby(ctx, roomID)
+}
+
+// AddChatMessage stores a room chat message in the room's chat history
+func (s *RoomService) AddChatMessage(ctx context.Context, message *model.ChatMessageData) error {
+	if s.config.ChatHistorySize <= 0 {
+		return nil
+	}
+	return s.roomRepo.AppendChatMessage(ctx, message.RoomID, message, s.config.ChatHistorySize)
+}
+
+// ChatHistory returns the room's recent chat messages, oldest first
+func (s *RoomService) ChatHistory(ctx context.Context, roomID string) ([]model.ChatMessageData, error) {
+	if s.config.ChatHistorySize <= 0 {
+		return nil, nil
+	}
+	return s.roomRepo.GetChatHistory(ctx, roomID)
+}
+
+// requireModerator checks that actorID is a host or moderator of the room
+// and returns the room
+func (s *RoomService) requireModerator(ctx context.Context, actorID, roomID string) (*model.Room, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil || !containsUser(room.Users, actorID) || !room.RoleOf(actorID).CanModerate() {
+		return nil, ErrNotPermitted
+	}
+	return room, nil
+}
+
+// UpdatePolicy applies an owner's policy change and returns the updated room
+func (s *RoomService) UpdatePolicy(ctx context.Context, userID, roomID string, update model.RoomPolicyUpdateData) (*model.Room, error) {
+	return s.roomRepo.UpdateRoom(ctx, roomID, func(room *model.Room) error {
+		policy := &room.Policy
+		if policy.Owner != userID {
+			return ErrNotRoomOwner
+		}
+
+		if update.Locked != nil {
+			policy.Locked = *update.Locked
+		}
+		if update.AllowedUsers != nil {
+			policy.AllowedUsers = *update.AllowedUsers
+		}
+		if update.AllowedClaims != nil {
+			policy.AllowedClaims = *update.AllowedClaims
+		}
+		if update.Password != nil {
+			if err := policy.SetPassword(*update.Password); err != nil {
+				return err
+			}
+		}
+		if update.LobbyEnabled != nil {
+			policy.LobbyEnabled = *update.LobbyEnabled
+		}
+		if update.Owner != nil && *update.Owner != policy.Owner {
+			if !containsUser(room.Users, *update.Owner) {
+				return ErrOwnerNotMember
+			}
+			policy.Owner = *update.Owner
+		}
+		return nil
+	})
+}
+
+// LeaveRoom removes a user from a room. If the user was the host, hosting
+// passes to a moderator or else the longest-present member, whose ID is
+// returned as newHost.
+func (s *RoomService) LeaveRoom(ctx context.Context, userID, roomID string) (newHost string, err error) {
 	// Remove user from room
 	if err := s.roomRepo.RemoveUserFromRoom(ctx, roomID, userID); err != nil {
-		return err
+		return "", err
 	}
 
 	// Update user's room to empty
 	if err := s.userRepo.UpdateUserRoom(ctx, userID, ""); err != nil {
+		return "", err
+	}
+
+	_, err = s.roomRepo.UpdateRoom(ctx, roomID, func(room *model.Room) error {
+		wasHost := room.RoleOf(userID) == model.RoomRoleHost
+		room.SetRole(userID, model.RoomRoleParticipant)
+		room.RemovePublisher(userID)
+		if wasHost && len(room.Users) > 0 {
+			newHost = successor(room)
+			room.SetRole(newHost, model.RoomRoleHost)
+		}
+		return nil
+	})
+	if errors.Is(err, repository.ErrRoomNotFound) {
+		// The last member left and the room is gone
+		return "", nil
+	}
+
+	return newHost, err
+}
+
+// successor picks the next host: the earliest-joined moderator, or else the
+// earliest-joined member. room.Users is ordered by join time.
+func successor(room *model.Room) string {
+	for _, userID := range room.Users {
+		if room.RoleOf(userID) == model.RoomRoleModerator {
+			return userID
+		}
+	}
+	return room.Users[0]
+}
+
+// CheckModerator verifies that actorID may moderate targetID: both must be in
+// the room and the actor must be a host or moderator who outranks the target
+func (s *RoomService) CheckModerator(ctx context.Context, actorID, roomID, targetID string) error {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
 		return err
 	}
+	if room == nil || !containsUser(room.Users, actorID) {
+		return ErrNotPermitted
+	}
+	if !containsUser(room.Users, targetID) {
+		return ErrUserNotInRoom
+	}
 
+	role := room.RoleOf(actorID)
+	if !role.CanModerate() || !role.Outranks(room.RoleOf(targetID)) {
+		return ErrNotPermitted
+	}
 	return nil
 }
 
+// BanUser keeps a user out of the room for duration; zero bans for the life
+// of the room. A banned user waiting in the lobby is taken out of it.
+func (s *RoomService) BanUser(ctx context.Context, roomID, userID string, duration time.Duration) error {
+	if err := s.roomRepo.BanUser(ctx, roomID, userID, duration); err != nil {
+		return err
+	}
+	_, err := s.roomRepo.RemoveFromLobby(ctx, roomID, userID)
+	return err
+}
+
+// TransferHost makes targetID the host; the previous host becomes a moderator
+func (s *RoomService) TransferHost(ctx context.Context, actorID, roomID, targetID string) (*model.Room, error) {
+	return s.roomRepo.UpdateRoom(ctx, roomID, func(room *model.Room) error {
+		if room.RoleOf(actorID) != model.RoomRoleHost {
+			return ErrNotPermitted
+		}
+		if !containsUser(room.Users, targetID) {
+			return ErrUserNotInRoom
+		}
+		room.SetRole(targetID, model.RoomRoleHost)
+		return nil
+	})
+}
+
+// EndRoom deletes the room on behalf of its host and returns the members it had
+func (s *RoomService) EndRoom(ctx context.Context, actorID, roomID string) ([]string, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil || room.RoleOf(actorID) != model.RoomRoleHost {
+		return nil, ErrNotPermitted
+	}
+
+	return s.deleteRoom(ctx, room)
+}
+
+// CloseRoom deletes the room without a permission check, for operators,
+// and returns the members it had. It returns repository.ErrRoomNotFound if
+// there is no such room.
+func (s *RoomService) CloseRoom(ctx context.Context, roomID string) ([]string, error) {
+	room, err := s.roomRepo.GetRoom(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, repository.ErrRoomNotFound
+	}
+
+	return s.deleteRoom(ctx, room)
+}
+
+func (s *RoomService) deleteRoom(ctx context.Context, room *model.Room) ([]string, error) {
+	if err := s.roomRepo.DeleteRoom(ctx, room.ID); err != nil {
+		return nil, err
+	}
+	// A member's session may already have expired; the room is gone either way
+	for _, userID := range room.Users {
+		s.userRepo.UpdateUserRoom(ctx, userID, "")
+	}
+
+	return room.Users, nil
+}
+
 // GetRoom retrieves a room by ID
 func (s *RoomService) GetRoom(ctx context.Context, roomID string) (*model.Room, error) {
 	return s.roomRepo.GetRoom(ctx, roomID)
 }
 
+// ListRooms returns a page of rooms, most recently active first, and the
+// cursor of the next page
+func (s *RoomService) ListRooms(ctx context.Context, cursor string, limit int) ([]*model.Room, string, error) {
+	return s.roomRepo.ListRooms(ctx, cursor, limit)
+}
+
+// CountRooms returns the number of live rooms
+func (s *RoomService) CountRooms(ctx context.Context) (int, error) {
+	return s.roomRepo.CountRooms(ctx)
+}
+
 // GetRoomUsers retrieves all users in a room
 func (s *RoomService) GetRoomUsers(ctx context.Context, roomID string) ([]string, error) {
 	return s.roomRepo.GetRoomUsers(ctx, roomID)
@@ -89,15 +594,11 @@ func (s *RoomService) GetOtherUsersInRoom(ctx context.Context, roomID, excludeUs
 	return otherUsers, nil
 }
 
-// IsRoomFull checks if a room is at capacity
-func (s *RoomService) IsRoomFull(ctx context.Context, roomID string) (bool, error) {
-	room, err := s.roomRepo.GetRoom(ctx, roomID)
-	if err != nil {
-		return false, err
-	}
-	if room == nil {
-		return false, nil // Room doesn't exist, so not full
+func containsUser(userIDs []string, userID string) bool {
+	for _, id := range userIDs {
+		if id == userID {
+			return true
+		}
 	}
-
-	return !room.CanJoin(), nil
+	return false
 }
@@ -3,76 +3,536 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/signaling-server/internal/metrics"
 	"github.com/signaling-server/internal/model"
 	"github.com/signaling-server/internal/repository"
+	"github.com/signaling-server/internal/wsconn"
 	"github.com/signaling-server/pkg/logger"
 )
 
+// ErrUserNotConnected is returned when a message targets a user with no live connection on any node
+var ErrUserNotConnected = errors.New("target user not connected")
+
+var errUnknownMessageType = errors.New("unknown message type")
+
+// closeSessionReplaced is the WebSocket close code sent to a connection
+// taken over by a newer one for the same user
+const closeSessionReplaced = 4000
+
+// requestIDKey carries the request_id of the client message being handled
+type requestIDKey struct{}
+
+func requestIDFrom(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
 type SignalingService struct {
 	userService *UserService
 	roomService *RoomService
+	iceService  *ICEService
+	rateLimits  *RateLimitService
 	pubsub      repository.PubSub
+	presence    repository.Presence
 	logger      *logger.Logger
-	
+	config      SignalingConfig
+
 	// Connection management
 	connections map[string]*model.User
 	connMutex   sync.RWMutex
+
+	// Room leaves deferred until the reconnect grace period expires
+	pendingLeaves map[string]*time.Timer
+	leaveMutex    sync.Mutex
+
+	// Send queue overflow counters since start
+	droppedMessages     atomic.Uint64
+	overflowDisconnects atomic.Uint64
+
+	// draining is set once the node starts shutting down and closing once
+	// the drain period is over and remaining connections are being closed.
+	// removing counts RemoveConnection calls still cleaning up.
+	draining atomic.Bool
+	closing  atomic.Bool
+	removing atomic.Int32
+}
+
+// SendQueueStats summarises the outbound queues of local connections
+type SendQueueStats struct {
+	Connections int `json:"connections"`
+	// Queued is the number of messages waiting across all connections and
+	// MaxDepth the longest single queue
+	Queued   int `json:"queued"`
+	MaxDepth int `json:"max_depth"`
+	// Dropped counts ICE candidates discarded on overflow and
+	// OverflowDisconnects slow consumers closed on overflow
+	Dropped             uint64 `json:"dropped"`
+	OverflowDisconnects uint64 `json:"overflow_disconnects"`
+}
+
+// SignalingConfig holds the node-level settings of the signaling service
+type SignalingConfig struct {
+	// NodeID identifies this node on the cross-node routing channels
+	NodeID string
+	// PresenceTTL is how long a user's node registration lives without refresh
+	PresenceTTL time.Duration
+	// ReconnectGrace is how long a disconnected user keeps their room
+	// membership so a reconnect with the same session can resume
+	ReconnectGrace time.Duration
+}
+
+// routedMessage is the envelope published on a node channel when the
+// target user is connected to a different signaling node
+type routedMessage struct {
+	TargetID string         `json:"target_id"`
+	Message  *model.Message `json:"message"`
+	// SentAt is when the message was published, in Unix nanoseconds
+	SentAt int64 `json:"sent_at,omitempty"`
 }
 
 func NewSignalingService(
 	userService *UserService,
 	roomService *RoomService,
+	iceService *ICEService,
+	rateLimits *RateLimitService,
 	pubsub repository.PubSub,
+	presence repository.Presence,
+	config SignalingConfig,
 	logger *logger.Logger,
 ) *SignalingService {
 	return &SignalingService{
-		userService: userService,
-		roomService: roomService,
-		pubsub:      pubsub,
-		logger:      logger,
-		connections: make(map[string]*model.User),
+		userService:   userService,
+		roomService:   roomService,
+		iceService:    iceService,
+		rateLimits:    rateLimits,
+		pubsub:        pubsub,
+		presence:      presence,
+		logger:        logger,
+		config:        config,
+		connections:   make(map[string]*model.User),
+		pendingLeaves: make(map[string]*time.Timer),
 	}
 }
 
-// AddConnection adds a WebSocket connection
-func (s *SignalingService) AddConnection(userID string, conn *websocket.Conn, sessionID string) (*model.User, error) {
-	s.connMutex.Lock()
-	defer s.connMutex.Unlock()
+// Start subscribes to this node's routing channel and keeps the presence
+// registrations of local users alive until ctx is cancelled
+func (s *SignalingService) Start(ctx context.Context) error {
+	messages, err := s.pubsub.Subscribe(ctx, nodeChannel(s.config.NodeID))
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to node channel: %w", err)
+	}
+
+	// Presence keys may have expired while Redis was unreachable, so
+	// re-register local users as soon as the routing channel is back
+	s.pubsub.OnReconnect(func(channel string) {
+		if channel != nodeChannel(s.config.NodeID) {
+			return
+		}
+		s.log(ctx).Warnf("Routing subscription %s re-established, refreshing presence", channel)
+		s.refreshLocalPresence(ctx)
+	})
+
+	go s.consumeRoutedMessages(messages)
+	go s.refreshPresence(ctx)
+
+	s.log(ctx).Infof("Signaling node %s listening on %s", s.config.NodeID, nodeChannel(s.config.NodeID))
+	return nil
+}
+
+// NodeID returns the identifier of this signaling node
+func (s *SignalingService) NodeID() string {
+	return s.config.NodeID
+}
+
+// RoutingSubscribed reports whether this node is still subscribed to its
+// routing channel, without which users on other nodes cannot reach it
+func (s *SignalingService) RoutingSubscribed() bool {
+	return s.pubsub.Subscribed(nodeChannel(s.config.NodeID))
+}
+
+// StartDrain marks the node as shutting down so it stops reporting ready
+// and accepting connections
+func (s *SignalingService) StartDrain() {
+	s.draining.Store(true)
+}
+
+// Drain shuts the node down without cutting clients off. It stops accepting
+// connections, asks every client to reconnect elsewhere, waits up to period
+// for them to go, closes whoever is left with 1012 (service restart) and
+// finally removes every local user who has not reconnected to another node
+// from their room, instead of leaving that to reconnect grace timers that
+// would die with the process. Clients that reconnect elsewhere during the
+// period keep their room through session resumption.
+func (s *SignalingService) Drain(ctx context.Context, period time.Duration) {
+	s.StartDrain()
+
+	users := s.localUsers()
+	s.logger.Infof("Draining %d connections over %s", len(users), period)
+
+	deadline := time.Now().Add(period)
+	for _, user := range users {
+		msg := &model.Message{
+			Type:      model.MessageTypeServerDraining,
+			Timestamp: time.Now().Unix(),
+		}
+		// Spread reconnects over the first half of the period so the other
+		// nodes are not hit by every client at once
+		var jitter time.Duration
+		if period > 1 {
+			jitter = time.Duration(rand.Int63n(int64(period / 2)))
+		}
+		msg.Data, _ = json.Marshal(model.ServerDrainingData{
+			ReconnectAfterMS: jitter.Milliseconds(),
+			Deadline:         deadline.Unix(),
+			Message:          "Server is shutting down, please reconnect",
+		})
+		s.sendMessage(user, msg)
+	}
+
+	waitCtx, cancel := context.WithDeadline(ctx, deadline)
+	s.waitForConnections(waitCtx)
+	cancel()
+
+	if remaining := s.localUsers(); len(remaining) > 0 {
+		s.logger.Infof("Closing %d connections still open after the drain period", len(remaining))
+		s.closing.Store(true)
+		for _, user := range remaining {
+			user.Connection.Close(websocket.CloseServiceRestart, "server draining")
+		}
+		s.waitForConnections(ctx)
+	}
+
+	s.flushPendingLeaves(context.Background())
+	s.logger.Info("Drain complete")
+}
+
+// localUsers returns the users connected to this node
+func (s *SignalingService) localUsers() []*model.User {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	users := make([]*model.User, 0, len(s.connections))
+	for _, user := range s.connections {
+		users = append(users, user)
+	}
+	return users
+}
+
+// waitForConnections returns once every local connection has been removed
+// and cleaned up, or ctx is done
+func (s *SignalingService) waitForConnections(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for s.ConnectionCount() > 0 || s.removing.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Draining reports whether the node is shutting down
+func (s *SignalingService) Draining() bool {
+	return s.draining.Load()
+}
 
+// AddConnection adds a WebSocket connection from remoteIP. identity is nil
+// for anonymous connections. A user is connected at most once: a connection
+// the user still has, on this node or another, is sent session_replaced and
+// closed, and the new one takes over its room through session resumption.
+func (s *SignalingService) AddConnection(userID string, conn *wsconn.Conn, sessionID, remoteIP string, identity *model.Identity) (*model.User, error) {
 	user := &model.User{
 		ID:         userID,
 		SessionID:  sessionID,
+		RemoteIP:   remoteIP,
+		Identity:   identity,
 		Connection: conn,
+		Limiter:    s.rateLimits.NewConnectionLimiter(),
 		CreatedAt:  time.Now(),
 		LastSeen:   time.Now(),
 	}
+	if identity != nil {
+		user.DisplayName = identity.DisplayName
+	}
 
+	s.connMutex.Lock()
+	previous, replaced := s.connections[userID]
+	if !replaced {
+		metrics.ConnectionsActive.Inc()
+	}
 	s.connections[userID] = user
+	s.connMutex.Unlock()
 	s.logger.Infof("User connected: %s", userID)
 
+	ctx := context.Background()
+	if replaced {
+		s.logger.Infof("Replacing previous connection of user %s", userID)
+		s.closeReplaced(ctx, previous)
+	} else if nodeID, err := s.presence.GetUserNode(ctx, userID); err != nil {
+		s.logger.Errorf("Failed to look up node for user %s: %v", userID, err)
+	} else if nodeID != "" && nodeID != s.config.NodeID {
+		// That node closes the previous connection when this arrives
+		s.logger.Infof("Replacing previous connection of user %s on node %s", userID, nodeID)
+		if err := s.publishToNode(ctx, nodeID, userID, &model.Message{Type: model.MessageTypeSessionReplaced}); err != nil {
+			s.logger.Errorf("Failed to replace connection of user %s on node %s: %v", userID, nodeID, err)
+		}
+	}
+
+	if err := s.presence.SetUserNode(ctx, userID, s.config.NodeID, s.config.PresenceTTL); err != nil {
+		s.logger.Errorf("Failed to register presence for user %s: %v", userID, err)
+	}
+
 	return user, nil
 }
 
-// RemoveConnection removes a WebSocket connection
-func (s *SignalingService) RemoveConnection(userID string) {
+// closeReplaced tells a connection that a newer one took over and closes
+// it. The connection must already be out of s.connections, so that its
+// RemoveConnection leaves the user's presence and room to the new one. A
+// lobby place is given up, as on any reconnect.
+func (s *SignalingService) closeReplaced(ctx context.Context, user *model.User) {
+	if s.lobbyOf(user) != "" {
+		s.leaveLobby(ctx, user)
+	}
+
+	s.sendMessage(user, &model.Message{
+		Type:      model.MessageTypeSessionReplaced,
+		UserID:    user.ID,
+		Timestamp: time.Now().Unix(),
+	})
+	user.Connection.Close(closeSessionReplaced, "replaced by a newer connection")
+}
+
+// RemoveConnection removes a WebSocket connection. A connection that was
+// replaced by a newer one for the same user is already gone and left alone.
+func (s *SignalingService) RemoveConnection(user *model.User) {
+	userID := user.ID
 	s.connMutex.Lock()
-	defer s.connMutex.Unlock()
+	exists := s.connections[userID] == user
+	if exists {
+		delete(s.connections, userID)
+		metrics.ConnectionsActive.Dec()
+		s.removing.Add(1)
+	}
+	s.connMutex.Unlock()
 
-	if user, exists := s.connections[userID]; exists {
-		// Leave room if user is in one
-		if user.RoomID != "" {
-			ctx := context.Background()
-			s.handleLeaveRoom(ctx, user, user.RoomID)
+	if !exists {
+		return
+	}
+	defer s.removing.Add(-1)
+
+	ctx := context.Background()
+	if err := s.presence.RemoveUserNode(ctx, userID, s.config.NodeID); err != nil {
+		s.logger.Errorf("Failed to remove presence for user %s: %v", userID, err)
+	}
+
+	// Record the disconnect time so other nodes honour the reconnect grace period
+	if err := s.userService.UpdateUserActivity(ctx, userID); err != nil {
+		s.logger.Errorf("Failed to update user activity: %v", err)
+	}
+
+	// Lobby places are not kept across reconnects
+	if s.lobbyOf(user) != "" {
+		s.leaveLobby(ctx, user)
+	}
+
+	// Leave room if user is in one, giving them a chance to reconnect first
+	if roomID := s.roomOf(user); roomID != "" {
+		// Connections closed at the end of a drain leave at once; the
+		// process will not be around to run a grace timer
+		if s.config.ReconnectGrace > 0 && !s.closing.Load() {
+			s.scheduleLeave(userID, roomID)
+		} else if err := s.leaveRoom(ctx, userID, roomID); err != nil {
+			s.logger.Errorf("Failed to remove user %s from room %s: %v", userID, roomID, err)
 		}
-		
-		delete(s.connections, userID)
-		s.logger.Infof("User disconnected: %s", userID)
 	}
+
+	s.logger.Infof("User disconnected: %s", userID)
+}
+
+// ResumeSession cancels a pending leave for a reconnecting user, restores
+// their room if they are still a member and sends them session_resumed
+func (s *SignalingService) ResumeSession(ctx context.Context, user *model.User, session *model.UserSession) error {
+	s.cancelPendingLeave(user.ID)
+
+	resumed := model.SessionResumedData{UserID: user.ID}
+	if session.RoomID != "" {
+		members, err := s.roomService.GetRoomUsers(ctx, session.RoomID)
+		if err != nil {
+			return fmt.Errorf("failed to get room users: %w", err)
+		}
+
+		for _, memberID := range members {
+			if memberID == user.ID {
+				resumed.RoomID = session.RoomID
+				break
+			}
+		}
+
+		if resumed.RoomID != "" {
+			s.connMutex.Lock()
+			user.RoomID = resumed.RoomID
+			s.connMutex.Unlock()
+
+			others, _ := s.roomService.GetOtherUsersInRoom(ctx, resumed.RoomID, user.ID)
+			resumed.Users = append(s.filterConnectedUsers(ctx, others), user.ID)
+			if room, err := s.roomService.GetRoom(ctx, resumed.RoomID); err == nil && room != nil {
+				resumed.Settings = &room.Settings
+			}
+		}
+	}
+
+	s.log(ctx).Infof("User %s resumed session (room: %q)", user.ID, resumed.RoomID)
+
+	msg := &model.Message{
+		Type:      model.MessageTypeSessionResumed,
+		RoomID:    resumed.RoomID,
+		UserID:    user.ID,
+		Timestamp: time.Now().Unix(),
+	}
+	msg.Data, _ = json.Marshal(resumed)
+	if err := s.sendMessage(user, msg); err != nil {
+		return err
+	}
+
+	// A returning host or moderator picks up whoever queued up meanwhile
+	if resumed.RoomID != "" {
+		if room, err := s.roomService.GetRoom(ctx, resumed.RoomID); err == nil && room != nil && room.RoleOf(user.ID).CanModerate() {
+			s.sendLobbyRequests(ctx, resumed.RoomID, user.ID)
+		}
+	}
+	return nil
+}
+
+// scheduleLeave removes the user from the room once the reconnect grace period expires
+func (s *SignalingService) scheduleLeave(userID, roomID string) {
+	s.leaveMutex.Lock()
+	defer s.leaveMutex.Unlock()
+
+	if timer, exists := s.pendingLeaves[userID]; exists {
+		timer.Stop()
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(s.config.ReconnectGrace, func() {
+		s.leaveMutex.Lock()
+		if s.pendingLeaves[userID] != timer {
+			// Cancelled or superseded by a newer disconnect
+			s.leaveMutex.Unlock()
+			return
+		}
+		delete(s.pendingLeaves, userID)
+		s.leaveMutex.Unlock()
+
+		ctx := context.Background()
+		if s.IsUserConnected(ctx, userID) {
+			s.logger.Infof("User %s reconnected on another node, keeping room %s", userID, roomID)
+			return
+		}
+
+		s.logger.Infof("Reconnect grace period expired for user %s, leaving room %s", userID, roomID)
+		if err := s.leaveRoom(ctx, userID, roomID); err != nil {
+			s.logger.Errorf("Failed to remove user %s from room %s: %v", userID, roomID, err)
+		}
+	})
+	s.pendingLeaves[userID] = timer
+}
+
+// flushPendingLeaves runs every scheduled leave now, keeping only users who
+// have already reconnected to another node
+func (s *SignalingService) flushPendingLeaves(ctx context.Context) {
+	s.leaveMutex.Lock()
+	pending := make([]string, 0, len(s.pendingLeaves))
+	for userID, timer := range s.pendingLeaves {
+		timer.Stop()
+		pending = append(pending, userID)
+	}
+	s.pendingLeaves = make(map[string]*time.Timer)
+	s.leaveMutex.Unlock()
+
+	for _, userID := range pending {
+		if s.IsUserConnected(ctx, userID) {
+			continue
+		}
+		session, err := s.userService.GetUser(ctx, userID)
+		if err != nil || session == nil || session.RoomID == "" {
+			continue
+		}
+		if err := s.leaveRoom(ctx, userID, session.RoomID); err != nil {
+			s.log(ctx).Errorf("Failed to remove user %s from room %s: %v", userID, session.RoomID, err)
+		}
+	}
+}
+
+// cancelPendingLeave stops a scheduled leave for the user, if any
+func (s *SignalingService) cancelPendingLeave(userID string) {
+	s.leaveMutex.Lock()
+	defer s.leaveMutex.Unlock()
+
+	if timer, exists := s.pendingLeaves[userID]; exists {
+		timer.Stop()
+		delete(s.pendingLeaves, userID)
+	}
+}
+
+// SendICEServers sends the user STUN/TURN servers with fresh TURN
+// credentials. It is called on connect and again before the credentials expire.
+func (s *SignalingService) SendICEServers(userID string) error {
+	user, exists := s.GetConnection(userID)
+	if !exists {
+		return fmt.Errorf("user connection not found: %s", userID)
+	}
+
+	msg := &model.Message{
+		Type:      model.MessageTypeSTUNConfig,
+		Timestamp: time.Now().Unix(),
+	}
+	msg.Data, _ = json.Marshal(s.iceService.ServersFor(userID))
+	return s.sendMessage(user, msg)
+}
+
+// ICERefreshInterval is how often live connections need new TURN credentials, or zero
+func (s *SignalingService) ICERefreshInterval() time.Duration {
+	return s.iceService.RefreshInterval()
+}
+
+// RefreshConnections applies reloaded rate limits to the connections on this
+// node and sends them the reloaded ICE servers
+func (s *SignalingService) RefreshConnections() {
+	limits := s.rateLimits.LimiterConfig()
+	for _, user := range s.localUsers() {
+		user.Limiter.SetConfig(limits)
+		if err := s.SendICEServers(user.ID); err != nil {
+			s.logger.Debugf("Failed to send ICE servers to %s: %v", user.ID, err)
+		}
+	}
+}
+
+// roomOf returns the room the user is in. Routed messages change it from
+// the pub/sub consumer while the user's own messages are handled, so it is
+// only read under connMutex.
+func (s *SignalingService) roomOf(user *model.User) string {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+	return user.RoomID
+}
+
+// lobbyOf returns the room whose lobby the user waits in, read like roomOf
+func (s *SignalingService) lobbyOf(user *model.User) string {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+	return user.LobbyRoomID
 }
 
 // GetConnection retrieves a WebSocket connection
@@ -84,83 +544,218 @@ func (s *SignalingService) GetConnection(userID string) (*model.User, bool) {
 	return user, exists
 }
 
+// ConnectionCount returns the number of connections held by this node
+func (s *SignalingService) ConnectionCount() int {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+	return len(s.connections)
+}
+
+// RoomSizes returns how many local connections are in each room
+func (s *SignalingService) RoomSizes() map[string]int {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	sizes := make(map[string]int)
+	for _, user := range s.connections {
+		if user.RoomID != "" {
+			sizes[user.RoomID]++
+		}
+	}
+	return sizes
+}
+
 // HandleMessage processes incoming WebSocket messages
 func (s *SignalingService) HandleMessage(ctx context.Context, userID string, messageData []byte) error {
+	user, exists := s.GetConnection(userID)
+	if !exists {
+		return fmt.Errorf("user connection not found: %s", userID)
+	}
+
 	var msg model.Message
 	if err := json.Unmarshal(messageData, &msg); err != nil {
-		return fmt.Errorf("failed to unmarshal message: %w", err)
+		if !user.Limiter.Allow("invalid") {
+			metrics.MessagesHandled.WithLabelValues("invalid", "rate_limited").Inc()
+			return s.rateLimited(ctx, user, "invalid", "message", "Too many messages")
+		}
+		metrics.MessagesHandled.WithLabelValues("invalid", "invalid").Inc()
+		s.log(ctx).Warn("Rejected message that is not valid JSON", "error", err)
+		return s.sendValidationError(ctx, user, &model.ValidationError{Code: model.ErrorCodeInvalidMessage, Message: "Message is not valid JSON"})
 	}
 
 	msg.UserID = userID
 	msg.Timestamp = time.Now().Unix()
 
-	user, exists := s.GetConnection(userID)
-	if !exists {
-		return fmt.Errorf("user connection not found: %s", userID)
+	if roomID := s.roomOf(user); roomID != "" {
+		ctx = logger.NewContext(ctx, s.log(ctx).With(logger.FieldRoomID, roomID))
+	}
+	// Oversized IDs are rejected below and not echoed
+	if msg.RequestID != "" && len(msg.RequestID) <= model.MaxRequestIDLength {
+		ctx = context.WithValue(ctx, requestIDKey{}, msg.RequestID)
+		ctx = logger.NewContext(ctx, s.log(ctx).With(logger.FieldRequestID, msg.RequestID))
+	}
+
+	// Unknown types are folded together to keep the label set and the
+	// limiter's buckets bounded
+	msgType := string(msg.Type)
+	if !model.IsClientMessageType(msg.Type) {
+		msgType = "unknown"
+	}
+	if !user.Limiter.Allow(msgType) {
+		metrics.MessagesHandled.WithLabelValues(msgType, "rate_limited").Inc()
+		return s.rateLimited(ctx, user, msgType, "message", "Too many messages")
+	}
+
+	payload, err := model.ValidateMessage(&msg)
+	if err != nil {
+		metrics.MessagesHandled.WithLabelValues(msgType, "invalid").Inc()
+		s.log(ctx).Warnf("Rejected invalid %s message: %v", msgType, err)
+		return s.sendValidationError(ctx, user, err)
+	}
+
+	err = s.dispatch(ctx, user, &msg, payload)
+
+	result := "ok"
+	if err != nil {
+		result = "error"
 	}
+	metrics.MessagesHandled.WithLabelValues(msgType, result).Inc()
+
+	return err
+}
 
+// dispatch hands a client message and the payload ValidateMessage decoded
+// from it to the handler for its type
+func (s *SignalingService) dispatch(ctx context.Context, user *model.User, msg *model.Message, payload model.Payload) error {
 	switch msg.Type {
 	case model.MessageTypeJoinRoom:
-		return s.handleJoinRoom(ctx, user, &msg)
+		return s.handleJoinRoom(ctx, user, msg, &model.CreateRoomData{JoinRoomData: *payload.(*model.JoinRoomData)})
+	case model.MessageTypeCreateRoom:
+		return s.handleJoinRoom(ctx, user, msg, payload.(*model.CreateRoomData))
 	case model.MessageTypeLeaveRoom:
 		return s.handleLeaveRoom(ctx, user, msg.RoomID)
 	case model.MessageTypeOffer:
-		return s.handleOffer(ctx, user, &msg)
+		return s.handleOffer(ctx, user, msg)
 	case model.MessageTypeAnswer:
-		return s.handleAnswer(ctx, user, &msg)
+		return s.handleAnswer(ctx, user, msg)
 	case model.MessageTypeIceCandidate:
-		return s.handleIceCandidate(ctx, user, &msg)
+		return s.handleIceCandidate(ctx, user, msg)
+	case model.MessageTypeUpdateRoomPolicy:
+		return s.handleUpdateRoomPolicy(ctx, user, payload.(*model.RoomPolicyUpdateData))
+	case model.MessageTypeKickUser:
+		return s.handleRemoveUser(ctx, user, payload.(*model.ModerationData), false)
+	case model.MessageTypeBanUser:
+		return s.handleRemoveUser(ctx, user, payload.(*model.ModerationData), true)
+	case model.MessageTypeEndRoom:
+		return s.handleEndRoom(ctx, user)
+	case model.MessageTypeTransferHost:
+		return s.handleTransferHost(ctx, user, payload.(*model.ModerationData))
+	case model.MessageTypeRequestMute:
+		return s.handleRequestMute(ctx, user, payload.(*model.ModerationData))
+	case model.MessageTypeAdmit:
+		return s.handleAdmit(ctx, user, payload.(*model.ModerationData))
+	case model.MessageTypeDeny:
+		return s.handleDeny(ctx, user, payload.(*model.ModerationData))
+	case model.MessageTypeChatMessage:
+		return s.handleChatMessage(ctx, user, msg, payload.(*model.ChatMessageData))
 	default:
-		return fmt.Errorf("unknown message type: %s", msg.Type)
+		return fmt.Errorf("%w: %s", errUnknownMessageType, msg.Type)
 	}
 }
 
-// handleJoinRoom processes join room requests
-func (s *SignalingService) handleJoinRoom(ctx context.Context, user *model.User, msg *model.Message) error {
-	s.logger.Infof("Received join room message: %s", string(msg.Data))
+// handleJoinRoom processes join room and create room requests
+func (s *SignalingService) handleJoinRoom(ctx context.Context, user *model.User, msg *model.Message, joinData *model.CreateRoomData) error {
+	s.log(ctx).Debug("Received join room message", logger.FieldPayload, string(msg.Data))
+
+	start, outcome := time.Now(), "error"
+	defer func() {
+		metrics.JoinDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+	}()
 	
-	var joinData model.JoinRoomData
-	if err := json.Unmarshal(msg.Data, &joinData); err != nil {
-		s.logger.Errorf("Failed to unmarshal join room data: %v, raw data: %s", err, string(msg.Data))
-		return s.sendError(user, 400, "Invalid join room data")
+	// create_room carries the room settings on top of the join_room fields
+	var create *model.RoomSettingsData
+	if msg.Type == model.MessageTypeCreateRoom {
+		create = &joinData.Settings
 	}
-	
-	s.logger.Infof("Parsed join room data: %+v", joinData)
 
-	// If user is already in a room, leave it first
-	if user.RoomID != "" && user.RoomID != joinData.RoomID {
-		s.logger.Infof("User %s is already in room %s, leaving before joining %s", user.ID, user.RoomID, joinData.RoomID)
-		s.handleLeaveRoom(ctx, user, user.RoomID)
+	// Checked before touching the room, so clients over the limit cost no cleanup
+	allowed, err := s.rateLimits.AllowJoin(ctx, user.RemoteIP)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to check join rate for %s: %v", user.RemoteIP, err)
+	}
+	if !allowed {
+		outcome = "rate_limited"
+		return s.rateLimited(ctx, user, string(msg.Type), "join", "Too many room joins, try again later")
 	}
 
 	// Clean up disconnected users from the room before checking if it's full
 	if err := s.cleanupDisconnectedUsersFromRoom(ctx, joinData.RoomID); err != nil {
-		s.logger.Errorf("Failed to cleanup disconnected users from room %s: %v", joinData.RoomID, err)
+		s.log(ctx).Errorf("Failed to cleanup disconnected users from room %s: %v", joinData.RoomID, err)
 	}
 
-	// Check if room is full
-	isFull, err := s.roomService.IsRoomFull(ctx, joinData.RoomID)
-	if err != nil {
-		return s.sendError(user, 500, "Failed to check room status")
+	// Stop waiting in any other room's lobby
+	if lobbyRoomID := s.lobbyOf(user); lobbyRoomID != "" && lobbyRoomID != joinData.RoomID {
+		s.leaveLobby(ctx, user)
 	}
-	
-	// Log room status for debugging
-	roomUsers, _ := s.roomService.GetRoomUsers(ctx, joinData.RoomID)
-	s.logger.Infof("Room %s status: isFull=%v, current users=%v", joinData.RoomID, isFull, roomUsers)
-	
-	if isFull {
-		return s.sendMessage(user, &model.Message{
+
+	// Join room; capacity is enforced atomically by the repository
+	room, err := s.roomService.JoinRoom(ctx, JoinRequest{
+		UserID:      user.ID,
+		RoomID:      joinData.RoomID,
+		Identity:    user.Identity,
+		Password:    joinData.Password,
+		E2EE:        joinData.E2EE,
+		ReceiveOnly: joinData.ReceiveOnly,
+		Create:      create,
+	})
+	if errors.Is(err, ErrInvalidRoomSettings) {
+		outcome = "invalid"
+		return s.sendError(ctx, user, 400, err.Error())
+	}
+	if errors.Is(err, repository.ErrRoomExists) {
+		outcome = "denied"
+		return s.sendError(ctx, user, 409, "Room already exists")
+	}
+	if errors.Is(err, ErrLobbyWaiting) {
+		outcome = "lobby"
+		return s.enterLobby(ctx, user, joinData.RoomID)
+	}
+	var denied *JoinDeniedError
+	if errors.As(err, &denied) {
+		s.log(ctx).Infof("Room %s denied user %s: %s", joinData.RoomID, user.ID, denied.Reason)
+		outcome = "denied"
+		return s.sendJoinDenied(ctx, user, joinData.RoomID, denied.Reason)
+	}
+	if errors.Is(err, repository.ErrRoomFull) {
+		s.log(ctx).Infof("Room %s is full, rejecting user %s", joinData.RoomID, user.ID)
+		outcome = "full"
+		return s.reply(ctx, user, &model.Message{
 			Type:      model.MessageTypeRoomFull,
 			RoomID:    joinData.RoomID,
 			Timestamp: time.Now().Unix(),
 		})
 	}
-
-	// Join room
-	_, err = s.roomService.JoinRoom(ctx, user.ID, joinData.RoomID)
 	if err != nil {
-		s.logger.Errorf("Failed to join room %s for user %s: %v", joinData.RoomID, user.ID, err)
-		return s.sendError(user, 500, "Failed to join room")
+		s.log(ctx).Errorf("Failed to join room %s for user %s: %v", joinData.RoomID, user.ID, err)
+		return s.sendError(ctx, user, 500, "Failed to join room")
+	}
+
+	// A lobby entry for this room is moot once the user is in
+	if s.lobbyOf(user) == joinData.RoomID {
+		s.leaveLobby(ctx, user)
+	}
+
+	// Leave the previous room only once the new one has accepted the user,
+	// so a denied join does not drop them out of their current room
+	if previous := s.roomOf(user); previous != "" && previous != joinData.RoomID {
+		s.log(ctx).Infof("User %s moved from room %s to %s", user.ID, previous, joinData.RoomID)
+		if err := s.leaveRoom(ctx, user.ID, previous); err != nil {
+			s.log(ctx).Errorf("Failed to leave room %s for user %s: %v", previous, user.ID, err)
+		}
+		// leaveRoom cleared the stored room; point it back at the new one
+		if err := s.userService.JoinRoom(ctx, user.ID, joinData.RoomID); err != nil {
+			s.log(ctx).Errorf("Failed to update room for user %s: %v", user.ID, err)
+		}
 	}
 
 	// Update user's room
@@ -168,19 +763,20 @@ func (s *SignalingService) handleJoinRoom(ctx context.Context, user *model.User,
 	user.RoomID = joinData.RoomID
 	s.connMutex.Unlock()
 
-	s.logger.Infof("User %s successfully joined room %s", user.ID, joinData.RoomID)
+	s.log(ctx).Infof("User %s successfully joined room %s", user.ID, joinData.RoomID)
+	outcome = "joined"
 
 	// Get other users in the room and filter for only connected users
 	otherUsers, err := s.roomService.GetOtherUsersInRoom(ctx, joinData.RoomID, user.ID)
 	if err != nil {
-		s.logger.Errorf("Failed to get other users: %v", err)
+		s.log(ctx).Errorf("Failed to get other users: %v", err)
 	}
 	
 	// Filter out disconnected users
-	connectedUsers := s.filterConnectedUsers(otherUsers)
+	connectedUsers := s.filterConnectedUsers(ctx, otherUsers)
 	activeUsers := append(connectedUsers, user.ID) // Include the joining user
 	
-	s.logger.Infof("Room %s now has %d active users: %v", joinData.RoomID, len(activeUsers), activeUsers)
+	s.log(ctx).Debugf("Room %s now has %d active users: %v", joinData.RoomID, len(activeUsers), activeUsers)
 	
 	if len(connectedUsers) > 0 {
 		userJoinedMsg := &model.Message{
@@ -190,137 +786,663 @@ func (s *SignalingService) handleJoinRoom(ctx context.Context, user *model.User,
 			Timestamp: time.Now().Unix(),
 		}
 		
-		userData := model.UserJoinedData{
-			UserID: user.ID,
-			Users:  activeUsers,
-		}
-		userJoinedMsg.Data, _ = json.Marshal(userData)
+		userJoinedMsg.Data, _ = json.Marshal(joinedData(room, user.ID, activeUsers))
 
-		s.broadcastToUsers(connectedUsers, userJoinedMsg)
-		s.logger.Infof("Notified %d connected users about new user %s joining room %s", len(connectedUsers), user.ID, joinData.RoomID)
+		s.broadcastToUsers(ctx, connectedUsers, userJoinedMsg)
+		s.log(ctx).Infof("Notified %d connected users about new user %s joining room %s", len(connectedUsers), user.ID, joinData.RoomID)
 	}
 
-	// Send confirmation to joining user with only connected users
-	return s.sendMessage(user, &model.Message{
+	// Hosts and moderators pick up anyone already waiting in the lobby
+	if room.RoleOf(user.ID).CanModerate() {
+		defer s.sendLobbyRequests(ctx, joinData.RoomID, user.ID)
+	}
+
+	// Send confirmation to joining user with only connected users and the
+	// chat so far
+	joined := joinedData(room, user.ID, activeUsers)
+	joined.ChatHistory = s.chatHistory(ctx, joinData.RoomID)
+	confirmation := &model.Message{
 		Type:      model.MessageTypeUserJoined,
 		RoomID:    joinData.RoomID,
 		UserID:    user.ID,
 		Timestamp: time.Now().Unix(),
-		Data:      func() json.RawMessage { d, _ := json.Marshal(model.UserJoinedData{UserID: user.ID, Users: activeUsers}); return d }(),
-	})
+	}
+	confirmation.Data, _ = json.Marshal(joined)
+	if err := s.reply(ctx, user, confirmation); err != nil {
+		return err
+	}
+	return s.ack(ctx, user, msg.Type, joinData.RoomID, "")
+}
+
+// joinedData describes a join to the room's members
+func joinedData(room *model.Room, userID string, users []string) model.UserJoinedData {
+	return model.UserJoinedData{
+		UserID:     userID,
+		Users:      users,
+		Roles:      room.Roles,
+		Settings:   &room.Settings,
+		Publishers: room.Publishers,
+	}
 }
 
 // handleLeaveRoom processes leave room requests
 func (s *SignalingService) handleLeaveRoom(ctx context.Context, user *model.User, roomID string) error {
-	if user.RoomID == "" {
-		return nil // User not in a room
+	if s.lobbyOf(user) != "" {
+		s.leaveLobby(ctx, user)
 	}
-
-	// Get other users before leaving
-	otherUsers, err := s.roomService.GetOtherUsersInRoom(ctx, user.RoomID, user.ID)
-	if err != nil {
-		s.logger.Errorf("Failed to get other users: %v", err)
+	leftRoomID := s.roomOf(user)
+	if leftRoomID == "" {
+		return s.ack(ctx, user, model.MessageTypeLeaveRoom, roomID, "") // User not in a room
 	}
 
-	// Leave room
-	if err := s.roomService.LeaveRoom(ctx, user.ID, user.RoomID); err != nil {
-		return s.sendError(user, 500, "Failed to leave room")
+	if err := s.leaveRoom(ctx, user.ID, leftRoomID); err != nil {
+		s.log(ctx).Errorf("Failed to leave room %s for user %s: %v", leftRoomID, user.ID, err)
+		return s.sendError(ctx, user, 500, "Failed to leave room")
 	}
 
-	oldRoomID := user.RoomID
-
 	// Update user's room
 	s.connMutex.Lock()
 	user.RoomID = ""
 	s.connMutex.Unlock()
 
+	return s.ack(ctx, user, model.MessageTypeLeaveRoom, leftRoomID, "")
+}
+
+// leaveRoom removes the user from the room and notifies the remaining members
+func (s *SignalingService) leaveRoom(ctx context.Context, userID, roomID string) error {
+	// Get other users before leaving
+	otherUsers, err := s.roomService.GetOtherUsersInRoom(ctx, roomID, userID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to get other users: %v", err)
+	}
+
+	// Leave room
+	newHost, err := s.roomService.LeaveRoom(ctx, userID, roomID)
+	if err != nil {
+		return err
+	}
+
 	// Notify other users
 	if len(otherUsers) > 0 {
 		userLeftMsg := &model.Message{
 			Type:      model.MessageTypeUserLeft,
-			RoomID:    oldRoomID,
-			UserID:    user.ID,
+			RoomID:    roomID,
+			UserID:    userID,
 			Timestamp: time.Now().Unix(),
 		}
-		
+
 		userData := model.UserLeftData{
-			UserID: user.ID,
+			UserID: userID,
 			Users:  otherUsers,
 		}
 		userLeftMsg.Data, _ = json.Marshal(userData)
 
-		s.broadcastToUsers(otherUsers, userLeftMsg)
+		s.broadcastToUsers(ctx, otherUsers, userLeftMsg)
+	}
+
+	if newHost != "" {
+		s.announceHost(ctx, roomID, newHost, userID, otherUsers)
 	}
 
 	return nil
 }
 
-// handleOffer processes WebRTC offer messages
-func (s *SignalingService) handleOffer(ctx context.Context, user *model.User, msg *model.Message) error {
-	if user.RoomID == "" {
-		return s.sendError(user, 400, "User not in a room")
+// handleUpdateRoomPolicy lets the room owner change who may join and
+// announces the new policy to the room
+func (s *SignalingService) handleUpdateRoomPolicy(ctx context.Context, user *model.User, update *model.RoomPolicyUpdateData) error {
+	roomID := s.roomOf(user)
+	if roomID == "" {
+		return s.sendError(ctx, user, 400, "User not in a room")
 	}
 
-	s.logger.Infof("Handling offer from user %s to target %s", user.ID, msg.TargetID)
+	room, err := s.roomService.UpdatePolicy(ctx, user.ID, roomID, *update)
+	switch {
+	case errors.Is(err, ErrNotRoomOwner):
+		return s.sendError(ctx, user, 403, "Only the room owner can change the room policy")
+	case errors.Is(err, ErrOwnerNotMember):
+		return s.sendError(ctx, user, 400, "New owner must be in the room")
+	case err != nil:
+		s.log(ctx).Errorf("Failed to update policy for room %s: %v", roomID, err)
+		return s.sendError(ctx, user, 500, "Failed to update room policy")
+	}
 
-	// Forward offer to target user
-	if msg.TargetID != "" {
-		// Set the sender's user ID in the message
-		msg.UserID = user.ID
-		return s.forwardToUser(msg.TargetID, msg)
+	s.log(ctx).Infof("User %s updated policy for room %s", user.ID, room.ID)
+
+	policyMsg := &model.Message{
+		Type:      model.MessageTypeRoomPolicyUpdated,
+		RoomID:    room.ID,
+		UserID:    user.ID,
+		Timestamp: time.Now().Unix(),
 	}
+	policyMsg.Data, _ = json.Marshal(model.RoomPolicyData{
+		RoomID:            room.ID,
+		Owner:             room.Policy.Owner,
+		Locked:            room.Policy.Locked,
+		PasswordProtected: room.Policy.HasPassword(),
+		LobbyEnabled:      room.Policy.LobbyEnabled,
+		AllowedUsers:      room.Policy.AllowedUsers,
+		AllowedClaims:     room.Policy.AllowedClaims,
+	})
 
-	return s.sendError(user, 400, "Target user ID required for offer")
+	s.broadcastToUsers(ctx, s.filterConnectedUsers(ctx, room.Users), policyMsg)
+	return nil
+}
+
+// kickBanDuration keeps a kicked user out long enough that they can't simply rejoin
+const kickBanDuration = 5 * time.Minute
+
+// handleRemoveUser kicks or bans a member on behalf of a host or moderator.
+// A kick is a short ban; a ban lasts Duration seconds or the life of the room.
+func (s *SignalingService) handleRemoveUser(ctx context.Context, user *model.User, data *model.ModerationData, ban bool) error {
+	roomID, ok := s.moderationRoom(ctx, user, data)
+	if !ok {
+		return nil
+	}
+
+	if err := s.roomService.CheckModerator(ctx, user.ID, roomID, data.UserID); err != nil {
+		return s.sendModerationError(ctx, user, err)
+	}
+
+	duration, reason := kickBanDuration, "kicked"
+	if ban {
+		duration, reason = time.Duration(data.Duration)*time.Second, "banned"
+	}
+	if err := s.roomService.BanUser(ctx, roomID, data.UserID, duration); err != nil {
+		s.log(ctx).Errorf("Failed to ban user %s from room %s: %v", data.UserID, roomID, err)
+		return s.sendError(ctx, user, 500, "Failed to remove user")
+	}
+
+	s.log(ctx).Infof("User %s %s user %s from room %s", user.ID, reason, data.UserID, roomID)
+
+	removedMsg := &model.Message{
+		Type:      model.MessageTypeRemovedFromRoom,
+		RoomID:    roomID,
+		UserID:    user.ID,
+		Timestamp: time.Now().Unix(),
+	}
+	removedMsg.Data, _ = json.Marshal(model.RemovedFromRoomData{RoomID: roomID, Reason: reason, By: user.ID})
+	if err := s.forwardToUser(ctx, data.UserID, removedMsg); err != nil && !errors.Is(err, ErrUserNotConnected) {
+		s.log(ctx).Errorf("Failed to notify removed user %s: %v", data.UserID, err)
+	}
+
+	return s.leaveRoom(ctx, data.UserID, roomID)
+}
+
+// handleEndRoom closes the room for everyone on behalf of the host
+func (s *SignalingService) handleEndRoom(ctx context.Context, user *model.User) error {
+	roomID := s.roomOf(user)
+	if roomID == "" {
+		return s.sendError(ctx, user, 400, "User not in a room")
+	}
+
+	members, err := s.roomService.EndRoom(ctx, user.ID, roomID)
+	if err != nil {
+		return s.sendModerationError(ctx, user, err)
+	}
+
+	s.log(ctx).Infof("User %s ended room %s (%d members)", user.ID, roomID, len(members))
+
+	endedMsg := &model.Message{
+		Type:      model.MessageTypeRoomEnded,
+		RoomID:    roomID,
+		UserID:    user.ID,
+		Timestamp: time.Now().Unix(),
+	}
+	endedMsg.Data, _ = json.Marshal(model.RemovedFromRoomData{RoomID: roomID, Reason: "room_ended", By: user.ID})
+	s.broadcastToUsers(ctx, members, endedMsg)
+	return nil
+}
+
+// handleTransferHost hands hosting to another member
+func (s *SignalingService) handleTransferHost(ctx context.Context, user *model.User, data *model.ModerationData) error {
+	roomID, ok := s.moderationRoom(ctx, user, data)
+	if !ok {
+		return nil
+	}
+
+	room, err := s.roomService.TransferHost(ctx, user.ID, roomID, data.UserID)
+	if err != nil {
+		return s.sendModerationError(ctx, user, err)
+	}
+
+	s.announceHost(ctx, room.ID, data.UserID, user.ID, s.filterConnectedUsers(ctx, room.Users))
+	return nil
+}
+
+// handleRequestMute asks a member to mute their audio or video. Muting is
+// up to the client; the server only relays the request.
+func (s *SignalingService) handleRequestMute(ctx context.Context, user *model.User, data *model.ModerationData) error {
+	roomID, ok := s.moderationRoom(ctx, user, data)
+	if !ok {
+		return nil
+	}
+	if data.Kind == "" {
+		data.Kind = "audio"
+	}
+
+	if err := s.roomService.CheckModerator(ctx, user.ID, roomID, data.UserID); err != nil {
+		return s.sendModerationError(ctx, user, err)
+	}
+
+	muteMsg := &model.Message{
+		Type:      model.MessageTypeMuteRequested,
+		RoomID:    roomID,
+		UserID:    user.ID,
+		Timestamp: time.Now().Unix(),
+	}
+	muteMsg.Data, _ = json.Marshal(model.MuteRequestedData{Kind: data.Kind, By: user.ID})
+	return s.forwardToUser(ctx, data.UserID, muteMsg)
+}
+
+// moderationRoom returns the room a moderation request applies to, the
+// user's own, replying with an error and returning false if it is unusable
+func (s *SignalingService) moderationRoom(ctx context.Context, user *model.User, data *model.ModerationData) (string, bool) {
+	roomID := s.roomOf(user)
+	if roomID == "" {
+		s.sendError(ctx, user, 400, "User not in a room")
+		return "", false
+	}
+	if data.UserID == user.ID {
+		s.sendError(ctx, user, 400, "Cannot target yourself")
+		return "", false
+	}
+	return roomID, true
+}
+
+func (s *SignalingService) sendModerationError(ctx context.Context, user *model.User, err error) error {
+	switch {
+	case errors.Is(err, ErrNotPermitted):
+		return s.sendError(ctx, user, 403, "Not permitted")
+	case errors.Is(err, ErrUserNotInRoom):
+		return s.sendError(ctx, user, 404, "User is not in the room")
+	default:
+		s.log(ctx).Errorf("Moderation by user %s failed: %v", user.ID, err)
+		return s.sendError(ctx, user, 500, "Moderation failed")
+	}
+}
+
+// announceHost tells the given members who the room host is now
+func (s *SignalingService) announceHost(ctx context.Context, roomID, host, previousHost string, userIDs []string) {
+	s.log(ctx).Infof("User %s is now host of room %s", host, roomID)
+
+	hostMsg := &model.Message{
+		Type:      model.MessageTypeHostChanged,
+		RoomID:    roomID,
+		UserID:    host,
+		Timestamp: time.Now().Unix(),
+	}
+	hostMsg.Data, _ = json.Marshal(model.HostChangedData{RoomID: roomID, Host: host, PreviousHost: previousHost})
+	s.broadcastToUsers(ctx, userIDs, hostMsg)
+
+	// The new host may not have seen the lobby before
+	s.sendLobbyRequests(ctx, roomID, host)
+}
+
+// enterLobby records that the user is waiting for roomID, tells them so and
+// asks the room's hosts and moderators to admit them
+func (s *SignalingService) enterLobby(ctx context.Context, user *model.User, roomID string) error {
+	s.connMutex.Lock()
+	user.LobbyRoomID = roomID
+	s.connMutex.Unlock()
+
+	s.log(ctx).Infof("User %s is waiting in the lobby of room %s", user.ID, roomID)
+
+	requestMsg := s.lobbyMessage(model.MessageTypeLobbyRequest, roomID, user.ID, "")
+	s.broadcastToUsers(ctx, s.roomModerators(ctx, roomID), requestMsg)
+
+	return s.reply(ctx, user, s.lobbyMessage(model.MessageTypeLobbyWaiting, roomID, user.ID, ""))
+}
+
+// leaveLobby removes a local user from the lobby they are waiting in
+func (s *SignalingService) leaveLobby(ctx context.Context, user *model.User) {
+	s.connMutex.Lock()
+	roomID := user.LobbyRoomID
+	user.LobbyRoomID = ""
+	s.connMutex.Unlock()
+
+	waiting, err := s.roomService.LeaveLobby(ctx, roomID, user.ID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to remove user %s from lobby of room %s: %v", user.ID, roomID, err)
+		return
+	}
+	if waiting {
+		resolvedMsg := s.lobbyMessage(model.MessageTypeLobbyResolved, roomID, user.ID, "left")
+		s.broadcastToUsers(ctx, s.roomModerators(ctx, roomID), resolvedMsg)
+	}
+}
+
+// handleAdmit lets a waiting user into the room
+func (s *SignalingService) handleAdmit(ctx context.Context, user *model.User, data *model.ModerationData) error {
+	roomID, ok := s.moderationRoom(ctx, user, data)
+	if !ok {
+		return nil
+	}
+
+	room, err := s.roomService.AdmitFromLobby(ctx, user.ID, roomID, data.UserID)
+	var denied *JoinDeniedError
+	switch {
+	case errors.Is(err, repository.ErrRoomFull):
+		return s.sendError(ctx, user, 409, "Room is full")
+	case errors.Is(err, repository.ErrRoomNotFound):
+		return s.sendError(ctx, user, 404, "Room not found")
+	case errors.As(err, &denied):
+		// The waiting user is told why and leaves the lobby
+		s.forwardJoinDenied(ctx, data.UserID, roomID, denied.Reason)
+		resolvedMsg := s.lobbyMessage(model.MessageTypeLobbyResolved, roomID, data.UserID, "denied")
+		s.broadcastToUsers(ctx, s.roomModerators(ctx, roomID), resolvedMsg)
+		return s.sendError(ctx, user, 409, joinDeniedMessages[denied.Reason])
+	case err != nil:
+		return s.sendLobbyError(ctx, user, err)
+	}
+
+	s.log(ctx).Infof("User %s admitted user %s to room %s", user.ID, data.UserID, roomID)
+
+	others := s.filterConnectedUsers(ctx, room.Users)
+	joined := joinedData(room, data.UserID, others)
+
+	// The admitted user's node completes the join when this arrives
+	admitted := joined
+	admitted.ChatHistory = s.chatHistory(ctx, roomID)
+	admittedMsg := &model.Message{
+		Type:      model.MessageTypeLobbyAdmitted,
+		RoomID:    roomID,
+		UserID:    data.UserID,
+		Timestamp: time.Now().Unix(),
+	}
+	admittedMsg.Data, _ = json.Marshal(admitted)
+	if err := s.forwardToUser(ctx, data.UserID, admittedMsg); err != nil {
+		s.log(ctx).Errorf("Failed to notify admitted user %s: %v", data.UserID, err)
+	}
+
+	joinedMsg := &model.Message{
+		Type:      model.MessageTypeUserJoined,
+		RoomID:    roomID,
+		UserID:    data.UserID,
+		Timestamp: time.Now().Unix(),
+	}
+	joinedMsg.Data, _ = json.Marshal(joined)
+	var members []string
+	for _, userID := range others {
+		if userID != data.UserID {
+			members = append(members, userID)
+		}
+	}
+	s.broadcastToUsers(ctx, members, joinedMsg)
+
+	resolvedMsg := s.lobbyMessage(model.MessageTypeLobbyResolved, roomID, data.UserID, "admitted")
+	s.broadcastToUsers(ctx, s.roomModerators(ctx, roomID), resolvedMsg)
+	return nil
+}
+
+// handleDeny turns a waiting user away
+func (s *SignalingService) handleDeny(ctx context.Context, user *model.User, data *model.ModerationData) error {
+	roomID, ok := s.moderationRoom(ctx, user, data)
+	if !ok {
+		return nil
+	}
+
+	if err := s.roomService.DenyFromLobby(ctx, user.ID, roomID, data.UserID); err != nil {
+		return s.sendLobbyError(ctx, user, err)
+	}
+
+	s.log(ctx).Infof("User %s denied user %s entry to room %s", user.ID, data.UserID, roomID)
+
+	deniedMsg := s.lobbyMessage(model.MessageTypeLobbyDenied, roomID, data.UserID, "denied")
+	if err := s.forwardToUser(ctx, data.UserID, deniedMsg); err != nil && !errors.Is(err, ErrUserNotConnected) {
+		s.log(ctx).Errorf("Failed to notify denied user %s: %v", data.UserID, err)
+	}
+
+	resolvedMsg := s.lobbyMessage(model.MessageTypeLobbyResolved, roomID, data.UserID, "denied")
+	s.broadcastToUsers(ctx, s.roomModerators(ctx, roomID), resolvedMsg)
+	return nil
+}
+
+// sendLobbyRequests sends moderatorID a lobby_request for everyone still
+// waiting, dropping entries whose users have gone away
+func (s *SignalingService) sendLobbyRequests(ctx context.Context, roomID, moderatorID string) {
+	waiting, err := s.roomService.GetLobby(ctx, roomID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to get lobby of room %s: %v", roomID, err)
+		return
+	}
+
+	for _, userID := range waiting {
+		if !s.IsUserConnected(ctx, userID) {
+			s.roomService.LeaveLobby(ctx, roomID, userID)
+			continue
+		}
+		requestMsg := s.lobbyMessage(model.MessageTypeLobbyRequest, roomID, userID, "")
+		if err := s.forwardToUser(ctx, moderatorID, requestMsg); err != nil && !errors.Is(err, ErrUserNotConnected) {
+			s.log(ctx).Errorf("Failed to send lobby request to user %s: %v", moderatorID, err)
+		}
+	}
+}
+
+// roomModerators returns the room's hosts and moderators
+func (s *SignalingService) roomModerators(ctx context.Context, roomID string) []string {
+	room, err := s.roomService.GetRoom(ctx, roomID)
+	if err != nil || room == nil {
+		return nil
+	}
+
+	var moderators []string
+	for _, userID := range room.Users {
+		if room.RoleOf(userID).CanModerate() {
+			moderators = append(moderators, userID)
+		}
+	}
+	return moderators
+}
+
+func (s *SignalingService) lobbyMessage(msgType model.MessageType, roomID, userID, outcome string) *model.Message {
+	msg := &model.Message{
+		Type:      msgType,
+		RoomID:    roomID,
+		UserID:    userID,
+		Timestamp: time.Now().Unix(),
+	}
+	msg.Data, _ = json.Marshal(model.LobbyData{RoomID: roomID, UserID: userID, Outcome: outcome})
+	return msg
+}
+
+func (s *SignalingService) sendLobbyError(ctx context.Context, user *model.User, err error) error {
+	if errors.Is(err, ErrNotInLobby) {
+		return s.sendError(ctx, user, 404, "User is not waiting in the lobby")
+	}
+	return s.sendModerationError(ctx, user, err)
+}
+
+// handleOffer processes WebRTC offer messages
+func (s *SignalingService) handleOffer(ctx context.Context, user *model.User, msg *model.Message) error {
+	if s.roomOf(user) == "" {
+		return s.sendError(ctx, user, 400, "User not in a room")
+	}
+
+	s.log(ctx).Debugf("Handling offer from user %s to target %s", user.ID, msg.TargetID)
+
+	// Forward offer to target user
+	if msg.TargetID != "" {
+		return s.relay(ctx, user, msg)
+	}
+
+	return s.sendError(ctx, user, 400, "Target user ID required for offer")
 }
 
 // handleAnswer processes WebRTC answer messages
 func (s *SignalingService) handleAnswer(ctx context.Context, user *model.User, msg *model.Message) error {
-	if user.RoomID == "" {
-		return s.sendError(user, 400, "User not in a room")
+	if s.roomOf(user) == "" {
+		return s.sendError(ctx, user, 400, "User not in a room")
 	}
 
-	s.logger.Infof("Handling answer from user %s to target %s", user.ID, msg.TargetID)
+	s.log(ctx).Debugf("Handling answer from user %s to target %s", user.ID, msg.TargetID)
 
 	// Forward answer to target user
 	if msg.TargetID != "" {
-		// Set the sender's user ID in the message
-		msg.UserID = user.ID
-		return s.forwardToUser(msg.TargetID, msg)
+		return s.relay(ctx, user, msg)
 	}
 
-	return s.sendError(user, 400, "Target user ID required for answer")
+	return s.sendError(ctx, user, 400, "Target user ID required for answer")
 }
 
 // handleIceCandidate processes ICE candidate messages
 func (s *SignalingService) handleIceCandidate(ctx context.Context, user *model.User, msg *model.Message) error {
-	if user.RoomID == "" {
-		return s.sendError(user, 400, "User not in a room")
+	if s.roomOf(user) == "" {
+		return s.sendError(ctx, user, 400, "User not in a room")
 	}
 
-	s.logger.Infof("Handling ICE candidate from user %s to target %s", user.ID, msg.TargetID)
+	s.log(ctx).Debugf("Handling ICE candidate from user %s to target %s", user.ID, msg.TargetID)
 
 	// Forward ICE candidate to target user
 	if msg.TargetID != "" {
-		// Set the sender's user ID in the message
-		msg.UserID = user.ID
-		return s.forwardToUser(msg.TargetID, msg)
+		return s.relay(ctx, user, msg)
 	}
 
-	return s.sendError(user, 400, "Target user ID required for ICE candidate")
+	return s.sendError(ctx, user, 400, "Target user ID required for ICE candidate")
+}
+
+// handleChatMessage sends a chat message to the sender's room, or with a
+// target_id to that member only. The server assigns the message ID and time
+// and echoes the message back to the sender, carrying their request_id, so
+// every member sees the same message. Room messages are kept in the room's
+// chat history; direct messages are not stored.
+func (s *SignalingService) handleChatMessage(ctx context.Context, user *model.User, msg *model.Message, data *model.ChatMessageData) error {
+	roomID := s.roomOf(user)
+	if roomID == "" {
+		return s.sendError(ctx, user, 400, "User not in a room")
+	}
+
+	members, err := s.roomService.GetOtherUsersInRoom(ctx, roomID, user.ID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to get users of room %s: %v", roomID, err)
+		return s.sendError(ctx, user, 500, "Failed to send chat message")
+	}
+	if msg.TargetID != "" && !slices.Contains(members, msg.TargetID) {
+		return s.sendError(ctx, user, 404, "Target user not in the room")
+	}
+
+	now := time.Now()
+	chat := model.ChatMessageData{
+		ID:     uuid.New().String(),
+		RoomID: roomID,
+		From:   user.ID,
+		To:     msg.TargetID,
+		Text:   data.Text,
+		SentAt: now.UnixMilli(),
+	}
+	if chat.To == "" {
+		if err := s.roomService.AddChatMessage(ctx, &chat); err != nil {
+			s.log(ctx).Errorf("Failed to store chat message in room %s: %v", roomID, err)
+		}
+	}
+
+	chatMsg := &model.Message{
+		Type:      model.MessageTypeChatMessage,
+		RoomID:    roomID,
+		UserID:    user.ID,
+		TargetID:  chat.To,
+		Timestamp: now.Unix(),
+	}
+	chatMsg.Data, _ = json.Marshal(chat)
+	if chat.To == "" {
+		s.broadcastToUsers(ctx, members, chatMsg)
+	} else if err := s.forwardToUser(ctx, chat.To, chatMsg); err != nil {
+		if errors.Is(err, ErrUserNotConnected) {
+			return s.sendError(ctx, user, 404, "Target user not connected")
+		}
+		s.log(ctx).Errorf("Failed to send chat message to user %s: %v", chat.To, err)
+		return s.sendError(ctx, user, 500, "Failed to send chat message")
+	}
+
+	echo := *chatMsg
+	return s.reply(ctx, user, &echo)
+}
+
+// chatHistory returns the room's recent chat for a joining user. History
+// is a convenience, so failing to load it does not fail the join.
+func (s *SignalingService) chatHistory(ctx context.Context, roomID string) []model.ChatMessageData {
+	history, err := s.roomService.ChatHistory(ctx, roomID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to get chat history of room %s: %v", roomID, err)
+	}
+	return history
+}
+
+// relay forwards a peer-to-peer message from user to its target and
+// acknowledges delivery. The target sees the sender's user ID but not their
+// request_id. Delivery failures are only reported to clients that are
+// waiting on a request_id.
+func (s *SignalingService) relay(ctx context.Context, user *model.User, msg *model.Message) error {
+	msg.UserID = user.ID
+	msg.RequestID = ""
+
+	err := s.forwardToUser(ctx, msg.TargetID, msg)
+	switch {
+	case err != nil && requestIDFrom(ctx) == "":
+		return err
+	case errors.Is(err, ErrUserNotConnected):
+		return s.sendError(ctx, user, 404, "Target user not connected")
+	case err != nil:
+		s.log(ctx).Errorf("Failed to deliver %s to user %s: %v", msg.Type, msg.TargetID, err)
+		return s.sendError(ctx, user, 500, "Failed to deliver message")
+	}
+
+	return s.ack(ctx, user, msg.Type, s.roomOf(user), msg.TargetID)
 }
 
 // Helper methods
+
+// log returns the connection's logger carried by ctx, or the service logger
+func (s *SignalingService) log(ctx context.Context) *logger.Logger {
+	return logger.FromContext(ctx, s.logger)
+}
+
 func (s *SignalingService) sendMessage(user *model.User, msg *model.Message) error {
-	s.logger.Infof("Sending message to user %s: type=%s", user.ID, msg.Type)
-	if err := user.Connection.WriteJSON(msg); err != nil {
+	s.logger.Debugf("Sending message to user %s: type=%s", user.ID, msg.Type)
+
+	// ICE candidates may be dropped under load; WebRTC recovers from losing some
+	send := user.Connection.Send
+	if msg.Type == model.MessageTypeIceCandidate {
+		send = user.Connection.SendDroppable
+	}
+
+	err := send(msg)
+	switch {
+	case errors.Is(err, wsconn.ErrDropped):
+		s.droppedMessages.Add(1)
+		s.logger.Warnf("Send queue full, dropped %s for user %s", msg.Type, user.ID)
+		return nil
+	case errors.Is(err, wsconn.ErrQueueFull):
+		s.overflowDisconnects.Add(1)
+		s.logger.Warnf("Send queue full, disconnecting slow consumer %s", user.ID)
+		return err
+	case err != nil:
 		s.logger.Errorf("Failed to send message to user %s: %v", user.ID, err)
 		return err
 	}
-	s.logger.Infof("Message sent successfully to user %s", user.ID)
 	return nil
 }
 
-func (s *SignalingService) sendError(user *model.User, code int, message string) error {
+// SendQueueStats reports the current depth of local send queues
+func (s *SignalingService) SendQueueStats() SendQueueStats {
+	s.connMutex.RLock()
+	defer s.connMutex.RUnlock()
+
+	stats := SendQueueStats{
+		Connections:         len(s.connections),
+		Dropped:             s.droppedMessages.Load(),
+		OverflowDisconnects: s.overflowDisconnects.Load(),
+	}
+	for _, user := range s.connections {
+		depth := user.Connection.QueueDepth()
+		stats.Queued += depth
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+	return stats
+}
+
+func (s *SignalingService) sendError(ctx context.Context, user *model.User, code int, message string) error {
 	errorMsg := &model.Message{
 		Type:      model.MessageTypeError,
 		Timestamp: time.Now().Unix(),
@@ -332,33 +1454,295 @@ func (s *SignalingService) sendError(user *model.User, code int, message string)
 	}
 	errorMsg.Data, _ = json.Marshal(errorData)
 
-	return s.sendMessage(user, errorMsg)
+	return s.reply(ctx, user, errorMsg)
 }
 
-func (s *SignalingService) forwardToUser(targetUserID string, msg *model.Message) error {
-	targetUser, exists := s.GetConnection(targetUserID)
-	if !exists {
-		return fmt.Errorf("target user not connected: %s", targetUserID)
+// reply sends a direct response to the client message being handled,
+// echoing its request_id
+func (s *SignalingService) reply(ctx context.Context, user *model.User, msg *model.Message) error {
+	msg.RequestID = requestIDFrom(ctx)
+	return s.sendMessage(user, msg)
+}
+
+// ack confirms a request to a client waiting on its request_id. targetID is
+// set for peer-to-peer messages. Clients that set no request_id have nothing
+// to match an ack to and get none.
+func (s *SignalingService) ack(ctx context.Context, user *model.User, msgType model.MessageType, roomID, targetID string) error {
+	if requestIDFrom(ctx) == "" {
+		return nil
+	}
+
+	ackMsg := &model.Message{
+		Type:      model.MessageTypeAck,
+		RoomID:    roomID,
+		TargetID:  targetID,
+		Timestamp: time.Now().Unix(),
+	}
+	ackMsg.Data, _ = json.Marshal(model.AckData{For: msgType})
+	return s.reply(ctx, user, ackMsg)
+}
+
+// sendValidationError tells the user why their message was rejected
+func (s *SignalingService) sendValidationError(ctx context.Context, user *model.User, err error) error {
+	errorData := model.ErrorData{Code: 400, Reason: model.ErrorCodeInvalidPayload, Message: err.Error()}
+	var invalid *model.ValidationError
+	if errors.As(err, &invalid) {
+		errorData.Reason, errorData.Message = invalid.Code, invalid.Message
 	}
 
-	return s.sendMessage(targetUser, msg)
+	errorMsg := &model.Message{
+		Type:      model.MessageTypeError,
+		Timestamp: time.Now().Unix(),
+	}
+	errorMsg.Data, _ = json.Marshal(errorData)
+
+	return s.reply(ctx, user, errorMsg)
 }
 
-func (s *SignalingService) broadcastToUsers(userIDs []string, msg *model.Message) {
+// rateLimited refuses a message that exceeded a rate limit. Once the
+// connection has been refused too often it returns ErrRateLimitAbuse so the
+// caller closes it.
+func (s *SignalingService) rateLimited(ctx context.Context, user *model.User, msgType, limit, message string) error {
+	metrics.RateLimited.WithLabelValues(limit).Inc()
+	s.log(ctx).Warnf("Rate limited %s message from user %s", msgType, user.ID)
+
+	errorMsg := &model.Message{
+		Type:      model.MessageTypeError,
+		Timestamp: time.Now().Unix(),
+	}
+	errorMsg.Data, _ = json.Marshal(model.ErrorData{Code: 429, Reason: model.ErrorCodeRateLimited, Message: message})
+	if err := s.reply(ctx, user, errorMsg); err != nil {
+		return err
+	}
+
+	if user.Limiter.Violation() {
+		return ErrRateLimitAbuse
+	}
+	return nil
+}
+
+// sendJoinDenied tells the user the room policy refused their join
+func (s *SignalingService) sendJoinDenied(ctx context.Context, user *model.User, roomID string, reason model.JoinDeniedReason) error {
+	return s.reply(ctx, user, joinDeniedMessage(roomID, reason))
+}
+
+// forwardJoinDenied tells a user on any node that their admission from the
+// lobby was refused
+func (s *SignalingService) forwardJoinDenied(ctx context.Context, userID, roomID string, reason model.JoinDeniedReason) {
+	deniedMsg := joinDeniedMessage(roomID, reason)
+	deniedMsg.UserID = userID
+	if err := s.forwardToUser(ctx, userID, deniedMsg); err != nil && !errors.Is(err, ErrUserNotConnected) {
+		s.log(ctx).Errorf("Failed to notify denied user %s: %v", userID, err)
+	}
+}
+
+func joinDeniedMessage(roomID string, reason model.JoinDeniedReason) *model.Message {
+	deniedMsg := &model.Message{
+		Type:      model.MessageTypeJoinDenied,
+		RoomID:    roomID,
+		Timestamp: time.Now().Unix(),
+	}
+	deniedMsg.Data, _ = json.Marshal(model.JoinDeniedData{
+		RoomID:  roomID,
+		Reason:  reason,
+		Message: joinDeniedMessages[reason],
+	})
+	return deniedMsg
+}
+
+var joinDeniedMessages = map[model.JoinDeniedReason]string{
+	model.JoinDeniedLocked:           "The room is locked",
+	model.JoinDeniedNotAllowed:       "You are not allowed to join this room",
+	model.JoinDeniedPasswordRequired: "This room requires a password",
+	model.JoinDeniedInvalidPassword:  "Incorrect room password",
+	model.JoinDeniedBanned:           "You have been removed from this room",
+	model.JoinDeniedE2EERequired:     "This room requires end-to-end encryption",
+	model.JoinDeniedPublishersFull:   "Every publisher slot is taken; join receive-only to watch",
+}
+
+func (s *SignalingService) forwardToUser(ctx context.Context, targetUserID string, msg *model.Message) error {
+	err := s.routeToUser(ctx, targetUserID, msg)
+	switch {
+	case errors.Is(err, ErrUserNotConnected):
+		metrics.ForwardFailures.WithLabelValues("not_connected").Inc()
+	case err != nil:
+		metrics.ForwardFailures.WithLabelValues("error").Inc()
+	}
+	return err
+}
+
+// routeToUser delivers msg locally or publishes it to the target's node
+func (s *SignalingService) routeToUser(ctx context.Context, targetUserID string, msg *model.Message) error {
+	if targetUser, exists := s.GetConnection(targetUserID); exists {
+		return s.deliverLocal(targetUser, msg)
+	}
+
+	nodeID, err := s.presence.GetUserNode(ctx, targetUserID)
+	if err != nil {
+		return fmt.Errorf("failed to look up node for user %s: %w", targetUserID, err)
+	}
+	if nodeID == "" || nodeID == s.config.NodeID {
+		return fmt.Errorf("%w: %s", ErrUserNotConnected, targetUserID)
+	}
+
+	return s.publishToNode(ctx, nodeID, targetUserID, msg)
+}
+
+// deliverLocal sends msg to a user connected to this node, first applying any
+// connection state it implies. Room removals and lobby decisions are made on
+// whichever node handled the moderator's request, so the target's node
+// learns of them here.
+func (s *SignalingService) deliverLocal(user *model.User, msg *model.Message) error {
+	switch msg.Type {
+	case model.MessageTypeSessionReplaced:
+		s.connMutex.Lock()
+		current := s.connections[user.ID] == user
+		if current {
+			delete(s.connections, user.ID)
+			metrics.ConnectionsActive.Dec()
+		}
+		s.connMutex.Unlock()
+		if current {
+			s.closeReplaced(context.Background(), user)
+		}
+		return nil
+	case model.MessageTypeRemovedFromRoom, model.MessageTypeRoomEnded:
+		s.connMutex.Lock()
+		if user.RoomID == msg.RoomID {
+			user.RoomID = ""
+		}
+		s.connMutex.Unlock()
+	case model.MessageTypeLobbyAdmitted:
+		s.connMutex.Lock()
+		if user.LobbyRoomID == msg.RoomID {
+			user.LobbyRoomID = ""
+			user.RoomID = msg.RoomID
+		}
+		s.connMutex.Unlock()
+	case model.MessageTypeLobbyDenied, model.MessageTypeJoinDenied:
+		s.connMutex.Lock()
+		if user.LobbyRoomID == msg.RoomID {
+			user.LobbyRoomID = ""
+		}
+		s.connMutex.Unlock()
+	}
+
+	return s.sendMessage(user, msg)
+}
+
+func (s *SignalingService) broadcastToUsers(ctx context.Context, userIDs []string, msg *model.Message) {
 	for _, userID := range userIDs {
-		if user, exists := s.GetConnection(userID); exists {
-			if err := s.sendMessage(user, msg); err != nil {
-				s.logger.Errorf("Failed to send message to user %s: %v", userID, err)
-			}
+		if err := s.forwardToUser(ctx, userID, msg); err != nil && !errors.Is(err, ErrUserNotConnected) {
+			s.log(ctx).Errorf("Failed to send message to user %s: %v", userID, err)
+		}
+	}
+}
+
+// publishToNode hands a message for targetUserID to the node that holds its connection
+func (s *SignalingService) publishToNode(ctx context.Context, nodeID, targetUserID string, msg *model.Message) error {
+	payload, err := json.Marshal(routedMessage{TargetID: targetUserID, Message: msg, SentAt: time.Now().UnixNano()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal routed message: %w", err)
+	}
+
+	if err := s.pubsub.Publish(ctx, nodeChannel(nodeID), payload); err != nil {
+		return fmt.Errorf("failed to publish to node %s: %w", nodeID, err)
+	}
+	return nil
+}
+
+// consumeRoutedMessages delivers messages published to this node by other nodes
+func (s *SignalingService) consumeRoutedMessages(messages <-chan []byte) {
+	for payload := range messages {
+		var routed routedMessage
+		if err := json.Unmarshal(payload, &routed); err != nil {
+			s.logger.Errorf("Failed to unmarshal routed message: %v", err)
+			continue
+		}
+		if routed.Message == nil {
+			continue
+		}
+		if routed.SentAt > 0 {
+			metrics.PubSubLag.Observe(time.Since(time.Unix(0, routed.SentAt)).Seconds())
+		}
+
+		targetUser, exists := s.GetConnection(routed.TargetID)
+		if !exists {
+			s.logger.Warnf("Dropping routed %s message: user %s is no longer connected to node %s", routed.Message.Type, routed.TargetID, s.config.NodeID)
+			continue
+		}
+
+		if err := s.deliverLocal(targetUser, routed.Message); err != nil {
+			s.logger.Errorf("Failed to deliver routed message to user %s: %v", routed.TargetID, err)
+		}
+	}
+}
+
+// refreshPresence periodically renews presence registrations for local users
+func (s *SignalingService) refreshPresence(ctx context.Context) {
+	ticker := time.NewTicker(s.config.PresenceTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshLocalPresence(ctx)
 		}
 	}
 }
 
+// refreshLocalPresence renews the presence registration of every local user
+func (s *SignalingService) refreshLocalPresence(ctx context.Context) {
+	s.connMutex.RLock()
+	userIDs := make([]string, 0, len(s.connections))
+	for userID := range s.connections {
+		userIDs = append(userIDs, userID)
+	}
+	s.connMutex.RUnlock()
+
+	for _, userID := range userIDs {
+		if err := s.presence.SetUserNode(ctx, userID, s.config.NodeID, s.config.PresenceTTL); err != nil {
+			s.log(ctx).Errorf("Failed to refresh presence for user %s: %v", userID, err)
+		}
+	}
+}
+
+// IsUserConnected reports whether the user has a live connection on any node
+func (s *SignalingService) IsUserConnected(ctx context.Context, userID string) bool {
+	if _, exists := s.GetConnection(userID); exists {
+		return true
+	}
+
+	nodeID, err := s.presence.GetUserNode(ctx, userID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to look up node for user %s: %v", userID, err)
+		// Assume the user is still connected rather than evicting them on a lookup failure
+		return true
+	}
+	return nodeID != "" && nodeID != s.config.NodeID
+}
+
+// inReconnectGrace reports whether a disconnected user was seen recently
+// enough that they may still resume their session
+func (s *SignalingService) inReconnectGrace(ctx context.Context, userID string) bool {
+	user, err := s.userService.GetUser(ctx, userID)
+	if err != nil {
+		s.log(ctx).Errorf("Failed to get user %s: %v", userID, err)
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	return time.Since(user.LastSeen) < s.config.ReconnectGrace
+}
+
 // filterConnectedUsers filters a list of user IDs to only include those with active connections
-func (s *SignalingService) filterConnectedUsers(userIDs []string) []string {
+func (s *SignalingService) filterConnectedUsers(ctx context.Context, userIDs []string) []string {
 	var connectedUsers []string
 	for _, userID := range userIDs {
-		if _, exists := s.GetConnection(userID); exists {
+		if s.IsUserConnected(ctx, userID) {
 			connectedUsers = append(connectedUsers, userID)
 		}
 	}
@@ -374,22 +1758,33 @@ func (s *SignalingService) cleanupDisconnectedUsersFromRoom(ctx context.Context,
 
 	var disconnectedUsers []string
 	for _, userID := range roomUsers {
-		if _, exists := s.GetConnection(userID); !exists {
+		if !s.IsUserConnected(ctx, userID) && !s.inReconnectGrace(ctx, userID) {
 			disconnectedUsers = append(disconnectedUsers, userID)
 		}
 	}
 
 	// Remove disconnected users from the room
 	for _, userID := range disconnectedUsers {
-		s.logger.Infof("Removing disconnected user %s from room %s", userID, roomID)
-		if err := s.roomService.LeaveRoom(ctx, userID, roomID); err != nil {
-			s.logger.Errorf("Failed to remove disconnected user %s from room %s: %v", userID, roomID, err)
+		s.log(ctx).Infof("Removing disconnected user %s from room %s", userID, roomID)
+		newHost, err := s.roomService.LeaveRoom(ctx, userID, roomID)
+		if err != nil {
+			s.log(ctx).Errorf("Failed to remove disconnected user %s from room %s: %v", userID, roomID, err)
+			continue
+		}
+		if newHost != "" {
+			others, _ := s.roomService.GetOtherUsersInRoom(ctx, roomID, "")
+			s.announceHost(ctx, roomID, newHost, userID, s.filterConnectedUsers(ctx, others))
 		}
 	}
 
 	if len(disconnectedUsers) > 0 {
-		s.logger.Infof("Cleaned up %d disconnected users from room %s", len(disconnectedUsers), roomID)
+		s.log(ctx).Infof("Cleaned up %d disconnected users from room %s", len(disconnectedUsers), roomID)
 	}
 
 	return nil
 }
+
+// nodeChannel returns the pub/sub channel a signaling node listens on
+func nodeChannel(nodeID string) string {
+	return fmt.Sprintf("signaling:node:%s", nodeID)
+}
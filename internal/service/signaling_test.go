@@ -0,0 +1,407 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/signaling-server/internal/model"
+	"github.com/signaling-server/internal/repository"
+	"github.com/signaling-server/internal/wsconn"
+	"github.com/signaling-server/pkg/logger"
+)
+
+// testNode is a signaling service on the memory backend behind a bare
+// WebSocket endpoint, standing in for the handler
+type testNode struct {
+	service *SignalingService
+	rooms   *RoomService
+	server  *httptest.Server
+}
+
+func newTestNode(t *testing.T, config RoomConfig) *testNode {
+	t.Helper()
+
+	repo := repository.NewMemoryRepository()
+	t.Cleanup(func() { repo.Close() })
+
+	log, err := logger.New(logger.Config{Level: "error", Output: io.Discard})
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+
+	if config.Defaults.MaxParticipants == 0 {
+		config.Defaults.MaxParticipants = 10
+	}
+	users := NewUserService(repo)
+	rooms := NewRoomService(repo, repo, config)
+	node := &testNode{
+		service: NewSignalingService(users, rooms, NewICEService(ICEConfig{}), NewRateLimitService(repo, RateLimitConfig{}),
+			repo, repo, SignalingConfig{NodeID: "test-node", PresenceTTL: time.Minute}, log),
+		rooms: rooms,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := node.service.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	var upgrader websocket.Upgrader
+	node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _, err := users.GetOrCreateUser(r.Context(), r.URL.Query().Get("session"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn := wsconn.New(ws, wsconn.Config{})
+		defer conn.Close(websocket.CloseNormalClosure, "")
+
+		user, err := node.service.AddConnection(session.ID, conn, session.SessionID, "127.0.0.1", nil)
+		if err != nil {
+			return
+		}
+		defer node.service.RemoveConnection(user)
+
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			node.service.HandleMessage(context.Background(), session.ID, data)
+		}
+	}))
+	t.Cleanup(node.server.Close)
+
+	return node
+}
+
+// testClient is a connected client and the messages it has been sent
+type testClient struct {
+	t        *testing.T
+	id       string
+	conn     *websocket.Conn
+	messages chan model.Message
+}
+
+// connect opens a connection and waits for the server to assign the user ID
+func (n *testNode) connect(t *testing.T, session string) *testClient {
+	t.Helper()
+
+	url := "ws" + strings.TrimPrefix(n.server.URL, "http") + "/?session=" + session
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testClient{t: t, conn: conn, messages: make(chan model.Message, 64)}
+	go func() {
+		defer close(c.messages)
+		for {
+			var msg model.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+
+	// The connection is registered once the service knows a user on it
+	deadline := time.Now().Add(2 * time.Second)
+	for c.id == "" {
+		for _, user := range n.service.localUsers() {
+			if user.SessionID == session {
+				c.id = user.ID
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("connection of session %s was not added", session)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return c
+}
+
+func (c *testClient) send(msgType model.MessageType, requestID, targetID string, data any) {
+	c.t.Helper()
+
+	msg := model.Message{Type: msgType, RequestID: requestID, TargetID: targetID}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			c.t.Fatalf("marshal %s: %v", msgType, err)
+		}
+		msg.Data = raw
+	}
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatalf("send %s: %v", msgType, err)
+	}
+}
+
+// expect waits for the next message of msgType, skipping any others
+func (c *testClient) expect(msgType model.MessageType) model.Message {
+	c.t.Helper()
+
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("connection closed waiting for %s", msgType)
+			}
+			if msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			c.t.Fatalf("timed out waiting for %s", msgType)
+		}
+	}
+}
+
+// expectError waits for an error message and checks its code
+func (c *testClient) expectError(code int) {
+	c.t.Helper()
+
+	var data model.ErrorData
+	decode(c.t, c.expect(model.MessageTypeError), &data)
+	if data.Code != code {
+		c.t.Fatalf("got error %d (%s), want %d", data.Code, data.Message, code)
+	}
+}
+
+func decode(t *testing.T, msg model.Message, v any) {
+	t.Helper()
+	if err := model.DecodeData(msg.Data, v); err != nil {
+		t.Fatalf("decode %s: %v", msg.Type, err)
+	}
+}
+
+func (c *testClient) join(roomID string) model.UserJoinedData {
+	c.t.Helper()
+
+	c.send(model.MessageTypeJoinRoom, "join-"+roomID, "", model.JoinRoomData{RoomID: roomID})
+	var joined model.UserJoinedData
+	decode(c.t, c.expect(model.MessageTypeUserJoined), &joined)
+	c.expect(model.MessageTypeAck)
+	return joined
+}
+
+func TestJoinRoom(t *testing.T) {
+	node := newTestNode(t, RoomConfig{})
+	alice := node.connect(t, "alice")
+	bob := node.connect(t, "bob")
+
+	joined := alice.join("room-1")
+	if joined.UserID != alice.id || len(joined.Users) != 1 {
+		t.Fatalf("first join: got %+v", joined)
+	}
+	if joined.Roles[alice.id] != model.RoomRoleHost {
+		t.Errorf("first member is %q, want host", joined.Roles[alice.id])
+	}
+
+	joined = bob.join("room-1")
+	if len(joined.Users) != 2 {
+		t.Fatalf("second join: got users %v", joined.Users)
+	}
+
+	var announced model.UserJoinedData
+	decode(t, alice.expect(model.MessageTypeUserJoined), &announced)
+	if announced.UserID != bob.id {
+		t.Errorf("alice was told %s joined, want %s", announced.UserID, bob.id)
+	}
+}
+
+func TestJoinRoomFull(t *testing.T) {
+	node := newTestNode(t, RoomConfig{Defaults: model.RoomSettings{MaxParticipants: 1}})
+	alice := node.connect(t, "alice")
+	bob := node.connect(t, "bob")
+
+	alice.join("room-1")
+	bob.send(model.MessageTypeJoinRoom, "1", "", model.JoinRoomData{RoomID: "room-1"})
+	bob.expect(model.MessageTypeRoomFull)
+}
+
+func TestLeaveRoom(t *testing.T) {
+	node := newTestNode(t, RoomConfig{})
+	alice := node.connect(t, "alice")
+	bob := node.connect(t, "bob")
+	alice.join("room-1")
+	bob.join("room-1")
+
+	bob.send(model.MessageTypeLeaveRoom, "1", "", nil)
+	bob.expect(model.MessageTypeAck)
+	if left := alice.expect(model.MessageTypeUserLeft); left.UserID != bob.id {
+		t.Errorf("alice was told %s left, want %s", left.UserID, bob.id)
+	}
+
+	alice.send(model.MessageTypeLeaveRoom, "2", "", nil)
+	alice.expect(model.MessageTypeAck)
+	room, err := node.rooms.GetRoom(context.Background(), "room-1")
+	if err != nil {
+		t.Fatalf("GetRoom: %v", err)
+	}
+	if room != nil {
+		t.Errorf("room kept after its last member left: %+v", room)
+	}
+}
+
+func TestRelay(t *testing.T) {
+	node := newTestNode(t, RoomConfig{})
+	alice := node.connect(t, "alice")
+	bob := node.connect(t, "bob")
+	alice.join("room-1")
+	bob.join("room-1")
+
+	offer := model.OfferData{Type: "offer", SDP: "v=0\r\n"}
+	alice.send(model.MessageTypeOffer, "1", bob.id, offer)
+	alice.expect(model.MessageTypeAck)
+
+	got := bob.expect(model.MessageTypeOffer)
+	if got.UserID != alice.id {
+		t.Errorf("offer from %q, want %q", got.UserID, alice.id)
+	}
+	var data model.OfferData
+	decode(t, got, &data)
+	if data != offer {
+		t.Errorf("got offer %+v, want %+v", data, offer)
+	}
+
+	alice.send(model.MessageTypeOffer, "2", "nobody", offer)
+	alice.expectError(http.StatusNotFound)
+}
+
+func TestRelayOutsideRoom(t *testing.T) {
+	node := newTestNode(t, RoomConfig{})
+	alice := node.connect(t, "alice")
+	bob := node.connect(t, "bob")
+
+	alice.send(model.MessageTypeOffer, "1", bob.id, model.OfferData{Type: "offer", SDP: "v=0\r\n"})
+	alice.expectError(http.StatusBadRequest)
+}
+
+func TestLobby(t *testing.T) {
+	node := newTestNode(t, RoomConfig{LobbyEnabled: true})
+	host := node.connect(t, "host")
+	admitted := node.connect(t, "admitted")
+	denied := node.connect(t, "denied")
+
+	host.join("room-1")
+
+	admitted.send(model.MessageTypeJoinRoom, "1", "", model.JoinRoomData{RoomID: "room-1"})
+	admitted.expect(model.MessageTypeLobbyWaiting)
+	if request := host.expect(model.MessageTypeLobbyRequest); request.UserID != admitted.id {
+		t.Fatalf("lobby request for %s, want %s", request.UserID, admitted.id)
+	}
+
+	host.send(model.MessageTypeAdmit, "2", "", model.ModerationData{UserID: admitted.id})
+	var joined model.UserJoinedData
+	decode(t, admitted.expect(model.MessageTypeLobbyAdmitted), &joined)
+	if len(joined.Users) != 2 {
+		t.Errorf("admitted into a room of %v", joined.Users)
+	}
+	host.expect(model.MessageTypeLobbyResolved)
+
+	denied.send(model.MessageTypeJoinRoom, "3", "", model.JoinRoomData{RoomID: "room-1"})
+	denied.expect(model.MessageTypeLobbyWaiting)
+	host.send(model.MessageTypeDeny, "4", "", model.ModerationData{UserID: denied.id})
+	denied.expect(model.MessageTypeLobbyDenied)
+
+	// The admitted user can relay and the denied one cannot
+	admitted.send(model.MessageTypeOffer, "5", host.id, model.OfferData{Type: "offer", SDP: "v=0\r\n"})
+	admitted.expect(model.MessageTypeAck)
+	denied.send(model.MessageTypeOffer, "6", host.id, model.OfferData{Type: "offer", SDP: "v=0\r\n"})
+	denied.expectError(http.StatusBadRequest)
+}
+
+func TestLobbyAdmitWithoutPublisherSlot(t *testing.T) {
+	node := newTestNode(t, RoomConfig{LobbyEnabled: true, Defaults: model.RoomSettings{MaxPublishers: 1}})
+	host := node.connect(t, "host")
+	waiting := node.connect(t, "waiting")
+	host.join("room-1")
+
+	waiting.send(model.MessageTypeJoinRoom, "1", "", model.JoinRoomData{RoomID: "room-1"})
+	waiting.expect(model.MessageTypeLobbyWaiting)
+
+	host.send(model.MessageTypeAdmit, "2", "", model.ModerationData{UserID: waiting.id})
+	host.expectError(http.StatusConflict)
+
+	var denied model.JoinDeniedData
+	decode(t, waiting.expect(model.MessageTypeJoinDenied), &denied)
+	if denied.Reason != model.JoinDeniedPublishersFull {
+		t.Errorf("denied with %q, want %q", denied.Reason, model.JoinDeniedPublishersFull)
+	}
+
+	users, err := node.rooms.GetRoomUsers(context.Background(), "room-1")
+	if err != nil {
+		t.Fatalf("GetRoomUsers: %v", err)
+	}
+	if fmt.Sprint(users) != fmt.Sprint([]string{host.id}) {
+		t.Errorf("room members %v, want only the host", users)
+	}
+}
+
+func TestLobbyAdmitBanned(t *testing.T) {
+	node := newTestNode(t, RoomConfig{LobbyEnabled: true})
+	host := node.connect(t, "host")
+	waiting := node.connect(t, "waiting")
+	host.join("room-1")
+
+	waiting.send(model.MessageTypeJoinRoom, "1", "", model.JoinRoomData{RoomID: "room-1"})
+	waiting.expect(model.MessageTypeLobbyWaiting)
+
+	// A ban recorded while the admit is on its way refuses it
+	ctx := context.Background()
+	if err := node.rooms.roomRepo.BanUser(ctx, "room-1", waiting.id, time.Minute); err != nil {
+		t.Fatalf("BanUser: %v", err)
+	}
+	host.send(model.MessageTypeAdmit, "2", "", model.ModerationData{UserID: waiting.id})
+	host.expectError(http.StatusForbidden)
+
+	// Banning through the service also empties the lobby
+	if err := node.rooms.BanUser(ctx, "room-1", waiting.id, time.Minute); err != nil {
+		t.Fatalf("BanUser: %v", err)
+	}
+	lobby, err := node.rooms.GetLobby(ctx, "room-1")
+	if err != nil {
+		t.Fatalf("GetLobby: %v", err)
+	}
+	if len(lobby) != 0 {
+		t.Errorf("banned user still waiting: %v", lobby)
+	}
+}
+
+func TestLobbyAdmitLockedRoom(t *testing.T) {
+	node := newTestNode(t, RoomConfig{LobbyEnabled: true})
+	host := node.connect(t, "host")
+	waiting := node.connect(t, "waiting")
+	host.join("room-1")
+
+	waiting.send(model.MessageTypeJoinRoom, "1", "", model.JoinRoomData{RoomID: "room-1"})
+	waiting.expect(model.MessageTypeLobbyWaiting)
+
+	locked := true
+	host.send(model.MessageTypeUpdateRoomPolicy, "2", "", model.RoomPolicyUpdateData{Locked: &locked})
+	host.expect(model.MessageTypeRoomPolicyUpdated)
+
+	host.send(model.MessageTypeAdmit, "3", "", model.ModerationData{UserID: waiting.id})
+	host.expectError(http.StatusConflict)
+
+	var denied model.JoinDeniedData
+	decode(t, waiting.expect(model.MessageTypeJoinDenied), &denied)
+	if denied.Reason != model.JoinDeniedLocked {
+		t.Errorf("denied with %q, want %q", denied.Reason, model.JoinDeniedLocked)
+	}
+}
@@ -40,16 +40,50 @@ func (s *UserService) GetUser(ctx context.Context, userID string) (*model.UserSe
 	return s.userRepo.GetUser(ctx, userID)
 }
 
-// GetOrCreateUser gets an existing user or creates a new one
-func (s *UserService) GetOrCreateUser(ctx context.Context, sessionID string) (*model.UserSession, error) {
-	// Try to find existing user by session ID
-	// This is a simplified approach - in production, you might want to index by session ID
-	user, err := s.CreateUser(ctx, sessionID)
+// GetOrCreateUser returns the user bound to the session or creates a new one.
+// The boolean reports whether an existing user was found.
+func (s *UserService) GetOrCreateUser(ctx context.Context, sessionID string) (*model.UserSession, bool, error) {
+	user, err := s.userRepo.GetUserBySession(ctx, sessionID)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+	if user != nil {
+		return user, true, nil
 	}
 
-	return user, nil
+	user, err = s.CreateUser(ctx, sessionID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return user, false, nil
+}
+
+// GetOrCreateUserWithID returns the user with the given ID, creating it if
+// needed, and binds it to the current session. It is used for authenticated
+// connections whose user ID is the token subject. The boolean reports whether
+// an existing user was found.
+func (s *UserService) GetOrCreateUserWithID(ctx context.Context, userID, sessionID string) (*model.UserSession, bool, error) {
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	existed := user != nil
+	if !existed {
+		user = &model.UserSession{
+			ID:        userID,
+			CreatedAt: time.Now(),
+		}
+	}
+
+	user.SessionID = sessionID
+	user.LastSeen = time.Now()
+	if err := s.userRepo.SaveUser(ctx, user); err != nil {
+		return nil, false, err
+	}
+
+	return user, existed, nil
 }
 
 // UpdateUserActivity updates the user's last seen timestamp
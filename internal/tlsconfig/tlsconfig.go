@@ -0,0 +1,157 @@
+// Package tlsconfig builds the TLS configuration of the signaling server
+// and reloads its certificate when the files change
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/signaling-server/pkg/logger"
+)
+
+// Config locates the server certificate and key, both PEM encoded. When
+// ClientCAFile is set, clients may present a certificate signed by one of
+// its CAs; it is verified but only required where a handler asks for it.
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// New loads the certificate and returns a server TLS config that serves it
+// through reloader, so it can be swapped without a restart
+func New(config Config) (*tls.Config, *CertReloader, error) {
+	reloader, err := NewCertReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("no certificates found in client CA file %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, reloader, nil
+}
+
+// CertReloader holds the current server certificate and reloads it when
+// the certificate or key file changes
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	version fileVersion
+	// failed is the last version that did not load, so it is reported once
+	failed fileVersion
+}
+
+// fileVersion identifies the contents of the certificate and key files by
+// their modification times and sizes
+type fileVersion struct {
+	certMod, keyMod   time.Time
+	certSize, keySize int64
+}
+
+// NewCertReloader loads the certificate and key
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate. It fits
+// tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the files if they changed since the last load and reports
+// whether it did. A certificate that fails to load leaves the current one in
+// place and is not retried until the files change again.
+func (r *CertReloader) Reload() (bool, error) {
+	version, err := r.stat()
+	if err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	unchanged := r.cert != nil && (version == r.version || version == r.failed)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		r.mu.Lock()
+		r.failed = version
+		r.mu.Unlock()
+		return false, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert, r.version = &cert, version
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Watch checks the files for changes every interval until ctx is done.
+// Kubernetes updates mounted secrets by swapping a symlink, which changes
+// what the paths resolve to, so polling is used rather than file events.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration, log *logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				log.Errorf("Keeping current TLS certificate: %v", err)
+			} else if reloaded {
+				log.Infof("Reloaded TLS certificate from %s", r.certFile)
+			}
+		}
+	}
+}
+
+func (r *CertReloader) stat() (fileVersion, error) {
+	cert, err := os.Stat(r.certFile)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	key, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fileVersion{}, fmt.Errorf("failed to read TLS key: %w", err)
+	}
+	return fileVersion{
+		certMod:  cert.ModTime(),
+		keyMod:   key.ModTime(),
+		certSize: cert.Size(),
+		keySize:  key.Size(),
+	}, nil
+}
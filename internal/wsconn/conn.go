@@ -0,0 +1,199 @@
+package wsconn
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrClosed is returned when sending on a closed connection
+	ErrClosed = errors.New("connection closed")
+	// ErrQueueFull is returned when the send queue overflowed and the
+	// connection was closed as a slow consumer
+	ErrQueueFull = errors.New("send queue full, connection closed")
+	// ErrDropped is returned when a droppable message was discarded because
+	// the send queue was full; the connection stays open
+	ErrDropped = errors.New("send queue full, message dropped")
+)
+
+// OverflowPolicy decides what happens when a connection's send queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowDropICE discards droppable messages (ICE candidates, which
+	// WebRTC tolerates losing) and disconnects only when anything else overflows
+	OverflowDropICE OverflowPolicy = "drop_ice"
+	// OverflowDisconnect disconnects the slow consumer on any overflow
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+// Config configures a connection's send queue and writer
+type Config struct {
+	QueueSize    int
+	Overflow     OverflowPolicy
+	WriteTimeout time.Duration
+	PingInterval time.Duration
+}
+
+// Conn owns the write side of a WebSocket connection. Messages are queued by
+// any goroutine and written by a single writer goroutine that also sends
+// pings, since gorilla/websocket allows only one concurrent writer. Reads
+// still go through the underlying *websocket.Conn.
+type Conn struct {
+	ws     *websocket.Conn
+	config Config
+
+	queue   chan []byte
+	closing chan closeRequest
+	done    chan struct{}
+	once    sync.Once
+
+	dropped atomic.Uint64
+}
+
+type closeRequest struct {
+	code int
+	text string
+}
+
+// New wraps ws and starts its writer goroutine
+func New(ws *websocket.Conn, config Config) *Conn {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 256
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+	if config.PingInterval <= 0 {
+		config.PingInterval = 30 * time.Second
+	}
+
+	c := &Conn{
+		ws:      ws,
+		config:  config,
+		queue:   make(chan []byte, config.QueueSize),
+		closing: make(chan closeRequest, 1),
+		done:    make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// Send queues v, encoded as JSON. If the queue is full the connection is
+// closed and ErrQueueFull returned.
+func (c *Conn) Send(v interface{}) error {
+	return c.enqueue(v, false)
+}
+
+// SendDroppable queues v like Send, but under OverflowDropICE a full queue
+// discards v and returns ErrDropped instead of closing the connection
+func (c *Conn) SendDroppable(v interface{}) error {
+	return c.enqueue(v, c.config.Overflow == OverflowDropICE)
+}
+
+func (c *Conn) enqueue(v interface{}, droppable bool) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-c.done:
+		return ErrClosed
+	default:
+	}
+
+	select {
+	case c.queue <- data:
+		return nil
+	default:
+	}
+
+	if droppable {
+		c.dropped.Add(1)
+		return ErrDropped
+	}
+
+	c.Close(websocket.ClosePolicyViolation, "send queue overflow")
+	return ErrQueueFull
+}
+
+// Close flushes queued messages, sends a close frame with code and text and
+// closes the underlying connection. It is safe to call more than once.
+func (c *Conn) Close(code int, text string) {
+	select {
+	case c.closing <- closeRequest{code: code, text: text}:
+	default:
+		// A close is already pending
+	}
+}
+
+// Done is closed once the writer has stopped and the connection is closed
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// QueueDepth returns the number of messages waiting to be written
+func (c *Conn) QueueDepth() int {
+	return len(c.queue)
+}
+
+// Dropped returns how many droppable messages were discarded on overflow
+func (c *Conn) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+func (c *Conn) writeLoop() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+	defer c.shutdown()
+
+	for {
+		select {
+		case data := <-c.queue:
+			if err := c.write(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.write(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case req := <-c.closing:
+			c.flush()
+			c.write(websocket.CloseMessage, websocket.FormatCloseMessage(req.code, req.text))
+			return
+		}
+	}
+}
+
+// flush writes whatever is still queued, giving up at the first error
+func (c *Conn) flush() {
+	for {
+		select {
+		case data := <-c.queue:
+			if err := c.write(websocket.TextMessage, data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *Conn) write(messageType int, data []byte) error {
+	c.ws.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+	return c.ws.WriteMessage(messageType, data)
+}
+
+// shutdown closes the underlying connection, which also ends the reader
+func (c *Conn) shutdown() {
+	c.once.Do(func() {
+		close(c.done)
+		c.ws.Close()
+	})
+}
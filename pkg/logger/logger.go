@@ -1,44 +1,201 @@
 package logger
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 )
 
+// Field names shared by every component so log lines can be correlated
+const (
+	FieldUserID    = "user_id"
+	FieldRoomID    = "room_id"
+	FieldSessionID = "session_id"
+	FieldConnID    = "conn_id"
+	FieldPod       = "pod"
+	FieldRequestID = "request_id"
+	// FieldPayload carries raw message data and is redacted unless disabled
+	FieldPayload = "payload"
+)
+
+// redacted replaces the value of sensitive attributes
+const redacted = "[REDACTED]"
+
+// sensitiveFields hold SDP, ICE candidates or secrets and are redacted by default
+var sensitiveFields = map[string]bool{
+	FieldPayload: true,
+	"sdp":        true,
+	"candidate":  true,
+	"password":   true,
+}
+
+// Config selects the minimum level ("debug", "info", "warn" or "error"),
+// the output format ("text" or "json") and whether sensitive fields are redacted
+type Config struct {
+	Level  string
+	Format string
+	Redact bool
+	// Output defaults to stdout
+	Output io.Writer
+}
+
+// Logger is a leveled, structured logger built on log/slog. Child loggers
+// created with With share the handler and level and add their fields to
+// every record.
 type Logger struct {
-	info  *log.Logger
-	warn  *log.Logger
-	error *log.Logger
+	slog  *slog.Logger
+	level *slog.LevelVar
 }
 
-func New() *Logger {
-	return &Logger{
-		info:  log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile),
-		warn:  log.New(os.Stdout, "WARN: ", log.Ldate|log.Ltime|log.Lshortfile),
-		error: log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile),
+// New builds a logger from config, rejecting unknown levels and formats
+func New(config Config) (*Logger, error) {
+	level := new(slog.LevelVar)
+	if err := level.UnmarshalText([]byte(config.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", config.Level)
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+
+	options := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if config.Redact && sensitiveFields[attr.Key] {
+				return slog.String(attr.Key, redacted)
+			}
+			if attr.Key == slog.SourceKey {
+				// Keep file:line like the previous log.Lshortfile output
+				if source, ok := attr.Value.Any().(*slog.Source); ok {
+					source.File = shortFile(source.File)
+				}
+			}
+			return attr
+		},
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(config.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(config.Output, options)
+	case "json":
+		handler = slog.NewJSONHandler(config.Output, options)
+	default:
+		return nil, fmt.Errorf("invalid log format %q (expected \"text\" or \"json\")", config.Format)
 	}
+
+	return &Logger{slog: slog.New(handler), level: level}, nil
+}
+
+// Default returns an info level text logger with redaction, for use before
+// configuration is loaded
+func Default() *Logger {
+	l, _ := New(Config{Level: "info", Format: "text", Redact: true})
+	return l
 }
 
-func (l *Logger) Info(v ...interface{}) {
-	l.info.Println(v...)
+// With returns a child logger that adds the given key/value pairs to every record
+func (l *Logger) With(args ...interface{}) *Logger {
+	return &Logger{slog: l.slog.With(args...), level: l.level}
+}
+
+// SetLevel changes the minimum level of l and every logger sharing its handler
+func (l *Logger) SetLevel(level string) error {
+	if err := l.level.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+	return nil
+}
+
+// Slog exposes the underlying *slog.Logger
+func (l *Logger) Slog() *slog.Logger {
+	return l.slog
+}
+
+// Enabled reports whether records at level would be written
+func (l *Logger) Enabled(level slog.Level) bool {
+	return l.slog.Enabled(context.Background(), level)
+}
+
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.log(slog.LevelDebug, msg, args...)
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.logf(slog.LevelDebug, format, v...)
+}
+
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.log(slog.LevelInfo, msg, args...)
 }
 
 func (l *Logger) Infof(format string, v ...interface{}) {
-	l.info.Printf(format, v...)
+	l.logf(slog.LevelInfo, format, v...)
 }
 
-func (l *Logger) Warn(v ...interface{}) {
-	l.warn.Println(v...)
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.log(slog.LevelWarn, msg, args...)
 }
 
 func (l *Logger) Warnf(format string, v ...interface{}) {
-	l.warn.Printf(format, v...)
+	l.logf(slog.LevelWarn, format, v...)
 }
 
-func (l *Logger) Error(v ...interface{}) {
-	l.error.Println(v...)
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.log(slog.LevelError, msg, args...)
 }
 
 func (l *Logger) Errorf(format string, v ...interface{}) {
-	l.error.Printf(format, v...)
+	l.logf(slog.LevelError, format, v...)
+}
+
+func (l *Logger) logf(level slog.Level, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, fmt.Sprintf(format, v...), nil)
+}
+
+func (l *Logger) log(level slog.Level, msg string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	l.write(level, msg, args)
+}
+
+// write records the caller of the exported method as the source
+func (l *Logger) write(level slog.Level, msg string, args []interface{}) {
+	var pcs [1]uintptr
+	runtime.Callers(4, pcs[:]) // skip Callers, write, log/logf and the exported method
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	record.Add(args...)
+	_ = l.slog.Handler().Handle(context.Background(), record)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying l
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or fallback if there is none
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
+
+// shortFile trims a source path to its final element
+func shortFile(file string) string {
+	if i := strings.LastIndexByte(file, '/'); i >= 0 {
+		return file[i+1:]
+	}
+	return file
 }
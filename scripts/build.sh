@@ -11,20 +11,21 @@ NC='\033[0m' # No Color
 # Configuration
 IMAGE_NAME="signaling-server"
 IMAGE_TAG="${IMAGE_TAG:-latest}"
+VERSION="${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}"
 COTURN_IMAGE_NAME="signaling-coturn"
 
 echo -e "${GREEN}Building WebRTC Signaling Server...${NC}"
 
 # Build Go application
 echo -e "${YELLOW}Building Go binary...${NC}"
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o signaling ./cmd/signaling
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -ldflags "-X main.version=${VERSION}" -o signaling ./cmd/signaling
 
 # Build Docker images
 echo -e "${YELLOW}Building Docker images...${NC}"
 
 # Build signaling server image
 echo -e "${YELLOW}Building signaling server image...${NC}"
-docker build -f deployments/docker/signaling/Dockerfile -t ${IMAGE_NAME}:${IMAGE_TAG} .
+docker build -f deployments/docker/signaling/Dockerfile --build-arg VERSION=${VERSION} -t ${IMAGE_NAME}:${IMAGE_TAG} .
 
 # Build coturn image
 echo -e "${YELLOW}Building coturn image...${NC}"
@@ -6,6 +6,12 @@ class WebRTCClient {
         this.iceServers = [];
         this.currentRoom = null;
         this.userId = null;
+        // Settings of the current room, from user_joined or session_resumed
+        this.roomSettings = null;
+        this.screenStream = null;
+        // Requests awaiting a reply, keyed by request_id
+        this.pendingRequests = new Map();
+        this.nextRequestId = 1;
         
         this.initializeElements();
         this.setupEventListeners();
@@ -19,6 +25,7 @@ class WebRTCClient {
             leaveBtn: document.getElementById('leaveBtn'),
             startVideoBtn: document.getElementById('startVideoBtn'),
             stopVideoBtn: document.getElementById('stopVideoBtn'),
+            shareScreenBtn: document.getElementById('shareScreenBtn'),
             clearLogsBtn: document.getElementById('clearLogsBtn'),
             connectionStatus: document.getElementById('connectionStatus'),
             roomStatus: document.getElementById('roomStatus'),
@@ -33,6 +40,7 @@ class WebRTCClient {
         this.elements.leaveBtn.addEventListener('click', () => this.leaveRoom());
         this.elements.startVideoBtn.addEventListener('click', () => this.startVideo());
         this.elements.stopVideoBtn.addEventListener('click', () => this.stopVideo());
+        this.elements.shareScreenBtn.addEventListener('click', () => this.toggleScreenShare());
         this.elements.clearLogsBtn.addEventListener('click', () => this.clearLogs());
         
         // Enter key support for room input
@@ -45,11 +53,21 @@ class WebRTCClient {
 
     connectWebSocket() {
         const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-        const wsUrl = `${protocol}//${window.location.host}/ws`;
+        // Tabs share the session cookie; the tab ID, which survives reloads,
+        // gives each tab its own user and lets it resume after a reconnect
+        let tabId = sessionStorage.getItem('tabId');
+        if (!tabId) {
+            tabId = Math.random().toString(36).slice(2, 12);
+            sessionStorage.setItem('tabId', tabId);
+        }
+        const wsUrl = `${protocol}//${window.location.host}/ws?tab=${tabId}`;
         
         this.log('Connecting to WebSocket...', 'info');
         
-        this.ws = new WebSocket(wsUrl);
+        // Browsers can't set headers on WebSocket requests, so a token from the
+        // page URL (?token=...) is passed as a subprotocol
+        const token = new URLSearchParams(window.location.search).get('token');
+        this.ws = token ? new WebSocket(wsUrl, ['access_token', token]) : new WebSocket(wsUrl);
         
         this.ws.onopen = () => {
             this.log('WebSocket connected', 'success');
@@ -60,17 +78,28 @@ class WebRTCClient {
             this.handleWebSocketMessage(JSON.parse(event.data));
         };
         
-        this.ws.onclose = () => {
+        this.ws.onclose = (event) => {
             this.log('WebSocket disconnected', 'warning');
             this.updateConnectionStatus(false);
+            this.rejectPendingRequests(new Error('WebSocket disconnected'));
             this.cleanup();
             
-            // Attempt to reconnect after 3 seconds
+            // Another tab or window took over this session; reconnecting
+            // would only take it back
+            if (this.replaced || event.code === 4000) {
+                this.log('Session continued in another connection', 'warning');
+                return;
+            }
+            
+            // Attempt to reconnect after 3 seconds, or at once when the
+            // server asked us to move
+            const delay = this.migrating || event.code === 1012 ? 0 : 3000;
+            this.migrating = false;
             setTimeout(() => {
                 if (!this.ws || this.ws.readyState === WebSocket.CLOSED) {
                     this.connectWebSocket();
                 }
-            }, 3000);
+            }, delay);
         };
         
         this.ws.onerror = (error) => {
@@ -80,11 +109,26 @@ class WebRTCClient {
 
     async handleWebSocketMessage(message) {
         this.log(`Received message: ${JSON.stringify(message)}`, 'info');
+        if (message.request_id) {
+            this.settleRequest(message);
+        }
         
         switch (message.type) {
+            case 'ack':
+                this.log(`Server acknowledged ${message.data.for}`, 'info');
+                break;
+                
             case 'stun_config':
                 this.iceServers = message.data.iceServers;
                 this.log(`STUN/TURN servers configured: ${JSON.stringify(this.iceServers)}`, 'info');
+                // Refreshed TURN credentials must reach existing connections too
+                for (const [userId, pc] of this.peerConnections) {
+                    try {
+                        pc.setConfiguration({ ...pc.getConfiguration(), iceServers: this.iceServers });
+                    } catch (e) {
+                        this.log(`Failed to update ICE servers for ${userId}: ${e}`, 'warning');
+                    }
+                }
                 break;
                 
             case 'user_joined':
@@ -92,6 +136,11 @@ class WebRTCClient {
                 await this.handleUserJoined(message);
                 break;
                 
+            case 'session_resumed':
+                this.log(`Processing session_resumed for user: ${message.user_id}`, 'info');
+                await this.handleSessionResumed(message);
+                break;
+                
             case 'user_left':
                 this.log(`Processing user_left message for user: ${message.user_id}`, 'info');
                 this.handleUserLeft(message);
@@ -117,6 +166,69 @@ class WebRTCClient {
                 alert('Room is full. Please try another room.');
                 break;
                 
+            case 'join_denied':
+                this.handleJoinDenied(message);
+                break;
+                
+            case 'removed_from_room':
+            case 'room_ended':
+                this.handleRemovedFromRoom(message);
+                break;
+                
+            case 'host_changed':
+                this.log(`New host: ${message.data.host}`, 'info');
+                break;
+                
+            case 'mute_requested':
+                this.handleMuteRequested(message);
+                break;
+                
+            case 'lobby_waiting':
+                this.log(`Waiting in the lobby of ${message.room_id}`, 'info');
+                this.updateRoomStatus(`Waiting for host: ${message.room_id}`);
+                break;
+                
+            case 'lobby_request':
+                this.handleLobbyRequest(message);
+                break;
+                
+            case 'lobby_admitted':
+                this.log(`Admitted to room ${message.room_id}`, 'success');
+                this.updateRoomStatus(`Joined: ${message.room_id}`);
+                await this.handleUserJoined(message);
+                break;
+                
+            case 'lobby_denied':
+                this.log(`Entry to room ${message.room_id} was denied`, 'error');
+                this.cleanup();
+                alert('The host did not let you in.');
+                break;
+                
+            case 'lobby_resolved':
+                this.log(`Lobby: ${message.data.user_id} ${message.data.outcome}`, 'info');
+                break;
+                
+            case 'room_policy_updated':
+                this.log(`Room policy updated: ${JSON.stringify(message.data)}`, 'info');
+                break;
+                
+            case 'chat_message':
+                this.handleChatMessage(message);
+                break;
+                
+            case 'system_notice':
+                this.log(`Notice: ${message.data.message}`, 'warning');
+                alert(message.data.message);
+                break;
+                
+            case 'session_replaced':
+                this.replaced = true;
+                break;
+                
+            case 'server_draining':
+                this.handleServerDraining(message);
+                break;
+                
             case 'error':
                 try {
                     const errorData = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
@@ -131,7 +243,7 @@ class WebRTCClient {
         }
     }
 
-    async joinRoom() {
+    async joinRoom(password, receiveOnly) {
         const roomId = this.elements.roomId.value.trim();
         if (!roomId) {
             alert('Please enter a room ID');
@@ -145,10 +257,12 @@ class WebRTCClient {
         
         const message = {
             type: 'join_room',
-            data: { room_id: roomId }
+            data: { room_id: roomId, password: password || undefined, receive_only: receiveOnly || undefined }
         };
+        this.receiveOnly = !!receiveOnly;
         
-        this.ws.send(JSON.stringify(message));
+        // Denials and room_full are handled as messages; only log the failure here
+        this.request(message).catch(error => this.log(`Join failed: ${error.message}`, 'error'));
         this.currentRoom = roomId;
         this.updateRoomStatus(`Joined: ${roomId}`);
         
@@ -159,6 +273,58 @@ class WebRTCClient {
         this.log(`Joining room: ${roomId}`, 'info');
     }
 
+    handleJoinDenied(message) {
+        const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+        this.log(`Join denied: ${data.reason}`, 'error');
+
+        this.currentRoom = null;
+        this.updateRoomStatus('Not in room');
+        this.elements.joinBtn.disabled = false;
+        this.elements.leaveBtn.disabled = true;
+        this.elements.roomId.disabled = false;
+
+        if (data.reason === 'password_required' || data.reason === 'invalid_password') {
+            const password = prompt(data.message);
+            if (password) {
+                this.joinRoom(password);
+            }
+            return;
+        }
+
+        if (data.reason === 'publishers_full') {
+            if (confirm(data.message)) {
+                this.joinRoom(undefined, true);
+            }
+            return;
+        }
+
+        alert(data.message);
+    }
+
+    handleRemovedFromRoom(message) {
+        const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+        this.log(`Removed from room ${data.room_id}: ${data.reason}`, 'warning');
+        this.cleanup();
+        alert(data.reason === 'room_ended' ? 'The host ended the meeting.' : 'You were removed from the room.');
+    }
+
+    handleLobbyRequest(message) {
+        const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+        this.log(`User ${data.user_id} is waiting in the lobby`, 'info');
+        const type = confirm(`Let ${data.user_id} into the room?`) ? 'admit' : 'deny';
+        this.ws.send(JSON.stringify({ type, data: { user_id: data.user_id } }));
+    }
+
+    handleMuteRequested(message) {
+        const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+        this.log(`Host asked to mute ${data.kind}`, 'warning');
+        if (this.localStream) {
+            this.localStream.getTracks()
+                .filter(track => track.kind === data.kind)
+                .forEach(track => { track.enabled = false; });
+        }
+    }
+
     leaveRoom() {
         if (!this.currentRoom) return;
         
@@ -167,7 +333,7 @@ class WebRTCClient {
             room_id: this.currentRoom
         };
         
-        this.ws.send(JSON.stringify(message));
+        this.request(message).catch(error => this.log(`Leave failed: ${error.message}`, 'warning'));
         this.cleanup();
         
         this.log(`Left room: ${this.currentRoom}`, 'info');
@@ -219,6 +385,65 @@ class WebRTCClient {
         this.log('Local video stopped', 'info');
     }
 
+    // The room's allow_screen_share setting decides whether we offer screen sharing
+    applyRoomSettings(settings) {
+        this.roomSettings = settings || null;
+        const allowed = !!(this.roomSettings && this.roomSettings.allow_screen_share);
+        if (!allowed) {
+            this.stopScreenShare();
+        }
+        this.elements.shareScreenBtn.disabled = !allowed;
+    }
+
+    async toggleScreenShare() {
+        if (this.screenStream) {
+            this.stopScreenShare();
+            return;
+        }
+        if (!this.roomSettings || !this.roomSettings.allow_screen_share) {
+            this.log('Screen sharing is not allowed in this room', 'warning');
+            return;
+        }
+
+        try {
+            this.screenStream = await navigator.mediaDevices.getDisplayMedia({ video: true });
+        } catch (error) {
+            this.log(`Failed to share screen: ${error.message}`, 'error');
+            return;
+        }
+
+        const screenTrack = this.screenStream.getVideoTracks()[0];
+        screenTrack.onended = () => this.stopScreenShare();
+        this.replaceVideoTrack(screenTrack);
+        this.elements.localVideo.srcObject = this.screenStream;
+        this.elements.shareScreenBtn.textContent = 'Stop Sharing';
+        this.log('Screen sharing started', 'success');
+    }
+
+    stopScreenShare() {
+        if (!this.screenStream) return;
+
+        this.screenStream.getTracks().forEach(track => track.stop());
+        this.screenStream = null;
+
+        // Go back to the camera, if it is on
+        const cameraTrack = this.localStream ? this.localStream.getVideoTracks()[0] : null;
+        this.replaceVideoTrack(cameraTrack || null);
+        this.elements.localVideo.srcObject = this.localStream;
+        this.elements.shareScreenBtn.textContent = 'Share Screen';
+        this.log('Screen sharing stopped', 'info');
+    }
+
+    replaceVideoTrack(track) {
+        for (const [userId, pc] of this.peerConnections) {
+            const sender = pc.getSenders().find(s => s.track && s.track.kind === 'video');
+            if (sender) {
+                sender.replaceTrack(track).catch(error =>
+                    this.log(`Failed to switch video for ${userId}: ${error.message}`, 'warning'));
+            }
+        }
+    }
+
     async handleUserJoined(message) {
         const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
         this.log(`User joined: ${message.user_id}`, 'success');
@@ -227,9 +452,13 @@ class WebRTCClient {
         if (!this.userId) {
             this.userId = message.user_id;
             this.log(`My user ID: ${this.userId}`, 'info');
+            this.applyRoomSettings(data.settings);
+            for (const chat of data.chat_history || []) {
+                this.handleChatMessage({ data: chat });
+            }
             
-            // Auto-start video when we join
-            if (!this.localStream) {
+            // Auto-start video when we join, unless we only watch
+            if (!this.localStream && !this.receiveOnly) {
                 await this.startVideo();
             }
             
@@ -259,6 +488,51 @@ class WebRTCClient {
         }
     }
 
+    handleServerDraining(message) {
+        const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+        const delay = data.reconnect_after_ms || 0;
+        this.log(`Server is draining, reconnecting in ${delay}ms`, 'warning');
+        
+        // Closing our side lets the session cookie resume the room on another pod
+        setTimeout(() => {
+            if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+                this.migrating = true;
+                this.ws.close(1000, 'server draining');
+            }
+        }, delay);
+    }
+
+    async handleSessionResumed(message) {
+        const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+        this.userId = data.user_id;
+        this.log(`Session resumed as ${this.userId}`, 'success');
+        
+        if (!data.room_id) {
+            return; // Room membership expired while we were disconnected
+        }
+        
+        this.currentRoom = data.room_id;
+        this.elements.roomId.value = data.room_id;
+        this.applyRoomSettings(data.settings);
+        this.updateRoomStatus(`Joined: ${data.room_id}`);
+        this.elements.joinBtn.disabled = true;
+        this.elements.leaveBtn.disabled = false;
+        this.elements.roomId.disabled = true;
+        
+        if (!this.localStream) {
+            await this.startVideo();
+        }
+        
+        // Our peer connections were torn down on disconnect, so offer fresh ones
+        for (const userId of data.users || []) {
+            if (userId !== this.userId) {
+                this.log(`Re-establishing peer connection with ${userId}`, 'info');
+                this.removePeerConnection(userId);
+                await this.createPeerConnection(userId, true);
+            }
+        }
+    }
+
     handleUserLeft(message) {
         this.log(`User left: ${message.user_id}`, 'warning');
         this.removePeerConnection(message.user_id);
@@ -334,7 +608,7 @@ class WebRTCClient {
                     await pc.setLocalDescription(offer);
                     
                     this.log(`Sending offer to ${userId}`, 'info');
-                    this.sendMessage({
+                    await this.request({
                         type: 'offer',
                         target_id: userId,
                         data: JSON.stringify({
@@ -342,6 +616,7 @@ class WebRTCClient {
                             type: offer.type
                         })
                     });
+                    this.log(`Offer delivered to ${userId}`, 'info');
                 } catch (error) {
                     this.log(`Failed to create/send offer to ${userId}: ${error.message}`, 'error');
                 }
@@ -353,6 +628,13 @@ class WebRTCClient {
         const offerData = JSON.parse(message.data);
         let pc = this.peerConnections.get(message.user_id);
         
+        // A peer that resumed its session after a network blip sends a fresh offer
+        if (pc && ['disconnected', 'failed', 'closed'].includes(pc.connectionState)) {
+            this.log(`Replacing stale peer connection with ${message.user_id}`, 'info');
+            this.removePeerConnection(message.user_id);
+            pc = null;
+        }
+        
         if (!pc) {
             await this.createPeerConnection(message.user_id, false);
             pc = this.peerConnections.get(message.user_id);
@@ -455,12 +737,88 @@ class WebRTCClient {
         }
     }
 
+    handleChatMessage(message) {
+        const chat = message.data;
+        const to = chat.to ? ` (to ${chat.to})` : '';
+        this.log(`Chat ${new Date(chat.sent_at).toLocaleTimeString()} ${chat.from}${to}: ${chat.text}`, 'info');
+    }
+
+    // sendChat sends text to the room, or to targetId only, and resolves with
+    // the message as the server stored and delivered it
+    sendChat(text, targetId = '') {
+        return this.request({
+            type: 'chat_message',
+            room_id: this.currentRoom,
+            target_id: targetId || undefined,
+            data: { text },
+        });
+    }
+
     sendMessage(message) {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(JSON.stringify(message));
         }
     }
 
+    // request sends a message tagged with a request_id and resolves with the
+    // server's ack, or rejects on an error reply, a refusal or timeout
+    request(message, timeoutMs = 10000) {
+        if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+            return Promise.reject(new Error('WebSocket not connected'));
+        }
+        const requestId = `req-${this.nextRequestId++}`;
+        return new Promise((resolve, reject) => {
+            const timer = setTimeout(() => {
+                this.pendingRequests.delete(requestId);
+                reject(new Error(`${message.type} timed out`));
+            }, timeoutMs);
+            this.pendingRequests.set(requestId, { resolve, reject, timer });
+            this.ws.send(JSON.stringify({ ...message, request_id: requestId }));
+        });
+    }
+
+    // settleRequest completes the request a reply belongs to. Other replies
+    // such as user_joined carry the request_id too but are followed by an ack.
+    settleRequest(message) {
+        const pending = this.pendingRequests.get(message.request_id);
+        if (!pending) return;
+
+        let error = null;
+        switch (message.type) {
+            case 'ack':
+            case 'lobby_waiting':
+            case 'chat_message':
+                break;
+            case 'error':
+            case 'join_denied': {
+                const data = typeof message.data === 'string' ? JSON.parse(message.data) : message.data;
+                error = new Error(data.message || message.type);
+                break;
+            }
+            case 'room_full':
+                error = new Error('Room is full');
+                break;
+            default:
+                return;
+        }
+
+        clearTimeout(pending.timer);
+        this.pendingRequests.delete(message.request_id);
+        if (error) {
+            pending.reject(error);
+        } else {
+            pending.resolve(message);
+        }
+    }
+
+    rejectPendingRequests(error) {
+        for (const pending of this.pendingRequests.values()) {
+            clearTimeout(pending.timer);
+            pending.reject(error);
+        }
+        this.pendingRequests.clear();
+    }
+
     cleanup() {
         this.log('Cleaning up client state', 'info');
         
@@ -477,6 +835,7 @@ class WebRTCClient {
         // Reset client state completely
         this.currentRoom = null;
         this.userId = null; // Reset user ID so we get a fresh one on rejoin
+        this.applyRoomSettings(null);
         
         // Reset UI state
         this.elements.joinBtn.disabled = false;